@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	sp "github.com/briandowns/spinner"
@@ -16,7 +17,7 @@ import (
 	"github.com/unleashable/apker/cmd/outputs"
 	. "github.com/unleashable/apker/cmd/utils"
 	"github.com/unleashable/apker/internal"
-	"github.com/unleashable/apker/internal/providers"
+	_ "github.com/unleashable/apker/internal/providers"
 	"github.com/unleashable/apker/internal/utils"
 	"github.com/urfave/cli/v2"
 )
@@ -48,6 +49,18 @@ var DeployFlags = []cli.Flag{
 		Name:  "password",
 		Usage: "Ask for ssh password instead of using private keys.",
 	},
+	&cli.BoolFlag{
+		Name:  "agent",
+		Usage: "Use ssh agent keys.",
+	},
+	&cli.StringFlag{
+		Name:  "password-file",
+		Usage: "Read ssh password from `file` (or set APKER_PASSWORD).",
+	},
+	&cli.StringFlag{
+		Name:  "passphrase-file",
+		Usage: "Read private key passphrase from `file` (or set APKER_PASSPHRASE).",
+	},
 	&cli.DurationFlag{
 		Name:    "timeout",
 		Aliases: []string{"t"},
@@ -81,121 +94,187 @@ var DeployFlags = []cli.Flag{
 		Aliases: []string{"ip"},
 		Usage:   "Deploy on already exists machine ip address.",
 	},
+	&cli.BoolFlag{
+		Name:    "verbose",
+		Aliases: []string{"v"},
+		Usage:   "Print remote commands output while running deploy steps.",
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Value:   "text",
+		Usage:   "Set output `format`: text or json (newline delimited events).",
+	},
+	&cli.StringFlag{
+		Name:  "log-file",
+		Usage: "Append remote commands output to log `file`.",
+	},
+	&cli.BoolFlag{
+		Name:  "redeploy",
+		Usage: "Run deploy steps on the last project machine recorded in state.",
+	},
+	&cli.StringFlag{
+		Name:  "knownhosts",
+		Usage: "knownhosts `file`, machines host keys are verified and added to it.",
+		Value: os.ExpandEnv("$HOME/.ssh/known_hosts"),
+	},
+	&cli.BoolFlag{
+		Name:  "no-pin-hostkey",
+		Usage: "Don't pin new machines host keys with cloud-init, trust them on first use.",
+	},
+	&cli.StringFlag{
+		Name:  "jump",
+		Usage: "Connect through jump `hosts`: comma separated [user@]host[:port]. (default: apker.yaml ssh.jump)",
+	},
+	&cli.StringFlag{
+		Name:  "ref",
+		Usage: "Deploy a git `ref`: branch, tag or commit. (default: repository default branch)",
+	},
+	&cli.BoolFlag{
+		Name:  "ephemeral-key",
+		Usage: "Deploy with a generated ed25519 key, removed from the machine after deploy.",
+	},
+	&cli.BoolFlag{
+		Name:  "keep-key",
+		Usage: "Keep the ephemeral key on the machine and store it in the deployment state.",
+	},
+	&cli.BoolFlag{
+		Name:  "remove-key",
+		Usage: "Remove the ssh key from the provider after deploy when apker registered it.",
+	},
+	&cli.BoolFlag{
+		Name:  "local",
+		Usage: "Deploy the current working tree instead of cloning the git repository.",
+	},
 }
 
 func Deploy(c *cli.Context) (e error) {
 
-	// Where are we?
-	cwd, e := os.Getwd()
-
-	if e != nil {
+	if e = setOutputFormat(c); e != nil {
 		return
 	}
 
-	// Init new project with the current working directory
-	project := internal.Project{
-		Path: cwd,
-		Temp: utils.Temp(),
-		Repo: c.String("url"),
-		Name: c.String("name"),
-		User: c.String("user"),
-		Addr: c.String("addr"),
-		Auth: os.Getenv("APKER_AUTH"),
-	}
+	// Override provider name if ip flag has a value.
+	if c.String("addr") != "" {
 
-	// Housekeeping.
-	defer os.RemoveAll(project.Temp)
+		if e = os.Setenv("APKER_PROVIDER", "custom"); e != nil {
+			return
+		}
+	}
 
-	var tmp []byte
+	project, e := NewDeployProject(c)
 
-RemoteGetYamlFile:
+	// Housekeeping.
+	if project != nil {
+		defer os.RemoveAll(project.Temp)
+	}
 
-	// Work from remote repo
-	if project.Repo != "" {
+	if e != nil {
+		return
+	}
 
-		// Get content of apker.yaml
-		if tmp, e = utils.GitFile(project.Repo, "apker.yaml", project.Auth); e != nil {
-			return
-		}
+	project.Addr = c.String("addr")
 
-		// Save apker.yaml to temp file
-		if e = ioutil.WriteFile(project.Temp+"/apker.yaml", tmp, 0600); e != nil {
-			return
-		}
+	// Resolve provider from apker.yaml
+	provider, info, e := internal.NewProvider(project)
 
-	} else {
+	if e != nil {
+		return
+	}
 
-		// Get remote url
-		if tmp, e = utils.Run("git", []string{"config", "--get", "remote.origin.url"}); e != nil {
+	// Set auth method.
+	if c.Bool("ephemeral-key") || c.Bool("keep-key") {
 
-			e = errors.New("Get remote repository url error: " + e.Error())
+		if e = setDeployKey(project, info, c); e != nil {
 			return
 		}
 
-		project.Repo = strings.TrimSpace(string(tmp))
-		goto RemoteGetYamlFile
-	}
+		// Jump hosts still use the user keys.
+		if project.Jump != "" {
 
-	// Override provider name if ip flag has a value.
-	if c.String("addr") != "" {
+			auth, err := ResolveAuth(c)
 
-		if e = os.Setenv("APKER_PROVIDER", "custom"); e != nil {
-			return
+			if err != nil {
+				return err
+			}
+
+			project.JumpAuth = auth.Methods
 		}
-	}
 
-	// Load project config from apker.yaml
-	if project.Config, e = internal.LoadConfig(project.Temp, c.StringSlice("parameter")); e != nil {
+	} else if e = SetAuthMethod(project, c); e != nil {
+
 		return
 	}
 
-	// Validate config.
-	if e = project.Config.Validate(); e != nil {
+	// Deploy to existing hosts.
+	if project.Addr == "" && len(project.Config.Deploy.Hosts) > 0 {
+
+		e = hostsDeploy(project, c)
 		return
-	}
 
-	// Project name fallback
-	if project.Name == "" && project.Config.Name != "" {
-		project.Name = "apker-" + project.Config.Name
-	}
+	} else if !info.Capabilities.Provision {
 
-	// Set auth method.
-	if e = SetAuthMethod(&project, c); e != nil {
+		e = customDeploy(project, c)
 		return
-	}
 
-	switch project.Config.Provider.Name {
-	case "digitalocean":
-		e = digitaloceanDeploy(&project, c)
-		break
-	case "custom":
-		e = customDeploy(&project, c)
-		break
-	default:
-		e = errors.New("Unknown provider name: " + project.Config.Provider.Name)
+	} else if utils.IsUrl(project.Config.Image.From) && !info.Capabilities.CustomImages {
+
+		e = fmt.Errorf("Provider %s does not support images from url.", info.Name)
+		return
 	}
 
+	e = machineDeploy(project, provider, c)
 	return
 }
 
-func digitaloceanDeploy(project *internal.Project, c *cli.Context) (e error) {
+func machineDeploy(project *internal.Project, provider internal.Provider, c *cli.Context) (e error) {
 
 	var (
-		sp               *sp.Spinner
-		do               *providers.Digitalocean
-		machine          internal.MachineStatus
-		MachineChan      chan internal.MachineStatus
-		installTimeout   <-chan time.Time
-		skipInputPrompts bool = c.Int("image") != 0 || c.Int("id") != 0
+		imageID   int = c.Int("image")
+		dropletID int = c.Int("id")
+		count     int = project.Config.Deploy.Count
+		projects  []*internal.Project
+		providers []internal.Provider   = []internal.Provider{provider}
+		state     internal.ProjectState = project.State.Project(project.Name)
 	)
 
-	if do, e = providers.NewDigitalocean(project); e != nil {
-		return
+	// Ephemeral keys are always removed from the provider.
+	if c.Bool("remove-key") || project.DeployKey != nil {
+		defer cleanup(&providers)
+	}
+
+	if count > 1 && dropletID != 0 {
+		return errors.New("The 'id' flag can't be used with deploy.count, use --redeploy instead.")
 	}
 
+	// Resolve machine or image from state.
+	if c.Bool("redeploy") && dropletID == 0 && count < 2 {
+
+		m, ok := state.Machine()
+
+		if !ok || m.ID == 0 {
+			return errors.New("No machine recorded for project: " + project.Name)
+		}
+
+		dropletID = m.ID
+		outputs.Success(fmt.Sprintf("Redeploy: %s (%d)", m.Name, m.ID), "")
+
+	} else if img, ok := state.Image(project.Config.Image.From); ok && imageID == 0 && img.Status == "available" {
+
+		imageID = img.ID
+		outputs.Success(fmt.Sprintf("Image: %s (%d)", img.Name, img.ID), "")
+	}
+
+	// Recorded images still need machine size and region.
+	skipInputPrompts := c.Int("image") != 0 || dropletID != 0 || (c.Bool("redeploy") && count > 1)
+
 	if skipInputPrompts {
 
-		goto DropletSetup
+		goto MachinesSetup
+
+	} else if e = checkMissingInputs(project, c); e != nil {
+
+		return
 
 	} else if project.Name == "" {
 
@@ -211,33 +290,178 @@ func digitaloceanDeploy(project *internal.Project, c *cli.Context) (e error) {
 		outputs.Success("Name: "+project.Name, "")
 	}
 
-	// Droplet size and region
-	if e = inputs.SetDropletSize(do, c.String("size")); e != nil {
+	// Machine size and region
+	if catalog, ok := provider.(internal.Catalog); ok {
 
-		return
+		if e = inputs.SetMachineSize(project, catalog, c.String("size")); e != nil {
 
-	} else if e = inputs.SetDropletRegion(do, c.String("region")); e != nil {
+			return
 
-		return
+		} else if e = inputs.SetMachineRegion(project, catalog, c.String("region")); e != nil {
+
+			return
+		}
+	}
+
+MachinesSetup:
+
+	if count < 2 {
+
+		// Timed out setup already printed how to resume.
+		if _, e = setupMachine(project, provider, imageID, dropletID, skipInputPrompts, false, c); e == errTimedOut {
+			return nil
+		} else if e != nil {
+			return
+		}
+
+		return runDeploy(project, outputs.Spinner(" Running deploy..."), c)
+	}
+
+	var (
+		first      int
+		dropletIDs = make([]int, count)
+		replicas   = make([]internal.Provider, count)
+		results    = make([]error, count)
+	)
+
+	for i := 1; i <= count; i++ {
+
+		replica := project.Replica(i)
+
+		if c.Bool("redeploy") {
+
+			m, ok := state.MachineByName(replica.Name)
+
+			if !ok || m.ID == 0 {
+				return errors.New("No machine recorded for: " + replica.Name)
+			}
+
+			dropletIDs[i-1] = m.ID
+		}
+
+		if replicas[i-1], _, e = internal.NewProvider(replica); e != nil {
+			return
+		}
+
+		projects = append(projects, replica)
+	}
+
+	providers = append(providers, replicas...)
+
+	// Image created once by the first machine and reused by other replicas.
+	if imageID == 0 && utils.IsUrl(project.Config.Image.From) {
+
+		if imageID, e = setupMachine(projects[0], replicas[0], imageID, dropletIDs[0], skipInputPrompts, false, c); e == errTimedOut {
+			return nil
+		} else if e != nil {
+			return
+		}
+
+		first = 1
+	}
+
+	// Machines are created concurrently, the strategy only sequences deploys.
+	utils.Parallel(count-first, 0, func(i int) {
+		i += first
+		_, results[i] = setupMachine(projects[i], replicas[i], imageID, dropletIDs[i], true, true, c)
+	})
+
+	for i, err := range results {
+
+		if err == errTimedOut {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s setup error: %s", projects[i].Name, err.Error())
+		}
+	}
+
+	return rolloutDeploy(projects, c)
+}
+
+// Generate a deploy key, it's injected by the provider in new machines only.
+func setDeployKey(project *internal.Project, info internal.ProviderInfo, c *cli.Context) error {
+
+	if !info.Capabilities.Provision || project.Addr != "" || len(project.Config.Deploy.Hosts) > 0 {
+		return fmt.Errorf("Ephemeral keys require a provider that creates machines, %s can't inject them.", info.Name)
+	} else if c.Bool("redeploy") || c.Int("id") != 0 {
+		return errors.New("Ephemeral keys can only be used with new machines, redeploys use the key stored with --keep-key.")
+	}
+
+	key, e := internal.NewDeployKey(project.Name, c.Bool("keep-key"))
+
+	if e != nil {
+		return e
 	}
 
-DropletSetup:
+	return project.SetDeployKey(key)
+}
+
+// Remove providers temporary resources.
+func cleanup(providers *[]internal.Provider) {
+
+	for _, provider := range *providers {
 
-	// Install image on digitalocean.
-	sp = outputs.Spinner(" Droplet setup...")
+		if cleaner, ok := provider.(internal.Cleaner); ok {
+
+			if e := cleaner.Cleanup(); e != nil {
+				outputs.Error("Cleanup error: "+e.Error(), "")
+			}
+		}
+	}
+}
+
+// Machine setup timed out with --no-timeout-error, deploy stops without error.
+var errTimedOut = errors.New("Machine setup timeout")
+
+// Setup a machine on the provider and wait for its ssh port, machines set up
+// concurrently have no spinner.
+func setupMachine(project *internal.Project, provider internal.Provider, imageID int, dropletID int, quiet bool, concurrent bool, c *cli.Context) (_ int, e error) {
+
+	var (
+		sp             *sp.Spinner
+		machine        internal.MachineStatus
+		MachineChan    chan internal.MachineStatus
+		installTimeout <-chan time.Time
+		spinner        = outputs.Spinner
+	)
+
+	if concurrent {
+		spinner = silentSpinner
+	}
+
+	// Install image on provider.
+	sp = spinner(" Machine setup: " + project.Name + "...")
 
 	// Timeout for install step for
 	installTimeout = time.After(c.Duration("timeout"))
 
 	// Installation channel
 	MachineChan = make(chan internal.MachineStatus)
-	defer close(MachineChan)
+	done := make(chan struct{})
+	attrs := internal.Attributes{
+		"imageId":   imageID,
+		"dropletId": dropletID,
+	}
 
 	// Go setup Image and droplet
-	go do.SetupMachine(MachineChan, internal.Attributes{
-		"imageId":   c.Int("image"),
-		"dropletId": c.Int("id"),
-	})
+	go func() {
+		provider.SetupMachine(MachineChan, attrs)
+		close(done)
+	}()
+
+	// Drain statuses until the provider returns, after a timeout
+	// or an error it may still be sending them.
+	defer func() {
+		go func() {
+			for {
+				select {
+				case <-MachineChan:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}()
 
 MachineLoop:
 
@@ -245,6 +469,20 @@ MachineLoop:
 		select {
 		case machine = <-MachineChan:
 
+			if machine.ImageID != 0 {
+				imageID = machine.ImageID
+			}
+
+			if outputs.JSONMode {
+				outputs.Emit(outputs.Event{
+					Type:    "machine.status",
+					Machine: project.Name,
+					Addr:    project.Addr,
+					Status:  machine.Status,
+					Error:   outputs.ErrorString(machine.Error),
+				})
+			}
+
 			// Handle status data
 			switch true {
 			case machine.Error != nil:
@@ -256,12 +494,12 @@ MachineLoop:
 
 				sp.Stop()
 
-				if skipInputPrompts == false {
+				if quiet == false {
 
-					outputs.Success("Droplet image created.", "")
+					outputs.Success("Machine image created.", "")
 				}
 
-				sp = outputs.Spinner(" Cheking droplet...")
+				sp = spinner(" Cheking machine...")
 				break
 
 			case machine.IsMachineReady:
@@ -272,7 +510,7 @@ MachineLoop:
 
 				if machine.IsMachineReady == false && machine.IsImageReady == false {
 
-					sp.Suffix = " Current droplet status: " + machine.Status
+					setSuffix(sp, " Current droplet status: "+machine.Status)
 				}
 			}
 
@@ -282,14 +520,18 @@ MachineLoop:
 			if int(c.Duration("timeout")) != 0 {
 
 				sp.Stop()
-				fmt.Printf("⌛ You can run: '%s --image %d' when image is ready.", strings.Join(os.Args, " "), do.ImageID)
 
-				if c.Bool("no-timeout-error") == false {
+				if outputs.JSONMode {
+					outputs.Emit(outputs.Event{Type: "timeout", Machine: project.Name, Message: fmt.Sprintf("Image %d is not ready yet.", imageID)})
+				} else {
+					fmt.Printf("⌛ You can run: '%s --image %d' when image is ready.", strings.Join(os.Args, " "), imageID)
+				}
 
-					e = errors.New("Installation timeout")
+				if c.Bool("no-timeout-error") {
+					return imageID, errTimedOut
 				}
 
-				return
+				return imageID, errors.New("Installation timeout")
 			}
 		}
 	}
@@ -297,94 +539,327 @@ MachineLoop:
 	sp.Stop()
 
 	if e != nil {
-		return
+		return imageID, e
 	}
 
-	// Now we have a droplet ready for action
-	outputs.Success("Droplet now ready.", "")
+	// Now we have a machine ready for action
+	outputMu.Lock()
+	outputs.Success("Machine now ready: "+project.Name, "")
+	outputMu.Unlock()
 
 	// Wait for ssh port
-	sp.Suffix = " Waiting for ssh port..."
+	setSuffix(sp, " Waiting for ssh port...")
 	sp.Start()
 
 	for {
 
 		time.Sleep(5 * time.Second)
 
-		if utils.IsPortOpen(project.Addr+":22", 5) {
+		if project.IsPortOpen(22, 5*time.Second) {
 			break
 		}
 	}
 
-	// Run deployment
-	e = runDeploy(project, sp, c.Bool("events"))
-	return
+	sp.Stop()
+	return imageID, nil
 }
 
-func customDeploy(project *internal.Project, c *cli.Context) (e error) {
+// In non-interactive mode, fail with all missing values instead of prompting.
+func checkMissingInputs(project *internal.Project, c *cli.Context) error {
 
-	sp := outputs.Spinner("Start...")
+	var missing []string
 
-	if project.Addr = c.String("addr"); project.Addr == "" {
+	if !inputs.NonInteractive {
+		return nil
+	}
 
-		sp.Stop()
+	if project.Name == "" {
+		missing = append(missing, "name: set --name flag or name in apker.yaml")
+	}
+
+	if c.String("size") == "" && project.Config.Image.Size == "" {
+		missing = append(missing, "size: set --size flag or image.size in apker.yaml")
+	}
+
+	if c.String("region") == "" {
+		missing = append(missing, "region: set --region flag")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing values in non-interactive mode:\n  - %s", strings.Join(missing, "\n  - "))
+	}
+
+	return nil
+}
+
+func customDeploy(project *internal.Project, c *cli.Context) (e error) {
+
+	if project.Addr == "" {
 		return errors.New("Please set machine ip address via 'addr' flag.")
 	}
 
-	return runDeploy(project, sp, c.Bool("events"))
+	return runDeploy(project, outputs.Spinner("Start..."), c)
+}
+
+// Deploy to deploy.hosts machines.
+func hostsDeploy(project *internal.Project, c *cli.Context) (e error) {
+
+	var projects []*internal.Project
+
+	for i, host := range project.Config.Deploy.Hosts {
+
+		t := parseTarget(host)
+
+		replica := project.Replica(i + 1)
+		replica.Addr = t.Addr
+
+		if t.User != "" {
+			replica.User = t.User
+		}
+
+		projects = append(projects, replica)
+	}
+
+	return rolloutDeploy(projects, c)
 }
 
-func runDeploy(project *internal.Project, sp *sp.Spinner, events bool) (e error) {
+func runDeploy(project *internal.Project, sp *sp.Spinner, c *cli.Context) (e error) {
+
+	started := time.Now()
 
 	// Deploy spinner!
-	sp.Suffix = " Running deploy..."
+	setSuffix(sp, " Running deploy...")
+
+	lines, e := newLineWriter(sp, c)
+
+	if e != nil {
+		sp.Stop()
+		return
+	}
+
+	defer lines.Close()
 
-	e = project.Deploy(events, stdout(sp), stderr(sp), progress(sp))
+	e = project.Deploy(c.Bool("events"), handlers(sp, project, "", lines))
 
 	sp.Stop()
 
+	emitResult(e, started)
+
 	if e == nil {
 
+		if project.Commit != "" {
+			outputs.Success(fmt.Sprintf("Commit: %s", project.Commit), "")
+		}
+
 		outputs.Success("It's 👏 Deployed 👏 successfully🚀!", "")
 	}
 
 	return
 }
 
-func progress(sp *sp.Spinner) internal.ProgressHandler {
+// Deploy to many machines using the project deploy strategy.
+func rolloutDeploy(projects []*internal.Project, c *cli.Context) (e error) {
+
+	var (
+		sp       = outputs.Spinner(" Running deploy...")
+		strategy = projects[0].Config.Deploy.Strategy
+		results  = make([]error, len(projects))
+		deployed = make([]bool, len(projects))
+		started  = time.Now()
+	)
+
+	lines, e := newLineWriter(sp, c)
+
+	if e != nil {
+		sp.Stop()
+		return
+	}
+
+	defer lines.Close()
+
+	e = strategy.Rollout(len(projects), func(i int) error {
+
+		prefix := fmt.Sprintf("[%s] ", projects[i].Name)
+
+		deployed[i] = true
+		results[i] = projects[i].Deploy(c.Bool("events"), handlers(sp, projects[i], prefix, lines))
+		return results[i]
+	})
+
+	sp.Stop()
+
+	emitResult(e, started)
+
+	for i, p := range projects {
+
+		switch {
+		case results[i] != nil:
+			outputs.Error(fmt.Sprintf("%s (%s): %s", p.Name, p.Addr, results[i].Error()), "")
+		case e != nil && deployed[i]:
+			outputs.Success(fmt.Sprintf("%s (%s): deployed", p.Name, p.Addr), "")
+		case e != nil:
+			outputs.Error(fmt.Sprintf("%s (%s): skipped", p.Name, p.Addr), "")
+		}
+	}
+
+	if e == nil {
+
+		if projects[0].Commit != "" {
+			outputs.Success(fmt.Sprintf("Commit: %s", projects[0].Commit), "")
+		}
+
+		outputs.Success(fmt.Sprintf("It's 👏 Deployed 👏 successfully on %d machines🚀!", len(projects)), "")
+	}
+
+	return
+}
+
+func handlers(sp *sp.Spinner, project *internal.Project, prefix string, lines *lineWriter) internal.Handlers {
+
+	if outputs.JSONMode {
+		return jsonHandlers(project, lines)
+	}
+
+	return internal.Handlers{
+		StdoutHandler:   stdout(sp, prefix),
+		StderrHandler:   stderr(sp, prefix),
+		ProgressHandler: progress(sp, prefix),
+		LineHandler:     lines.Handler(project, prefix),
+	}
+}
+
+// Deploy handlers that emit json events.
+func jsonHandlers(project *internal.Project, lines *lineWriter) internal.Handlers {
+
+	return internal.Handlers{
+		StdoutHandler: func(label string, log []byte) error {
+			outputs.Emit(outputs.Event{Type: "message", Status: "success", Machine: project.Name, Addr: project.Addr, Message: label})
+			return nil
+		},
+		StderrHandler: func(label string, log []byte) error {
+			outputs.Emit(outputs.Event{Type: "message", Status: "error", Machine: project.Name, Addr: project.Addr, Message: label, Output: string(log)})
+			return nil
+		},
+		ProgressHandler: func(label string) error {
+			outputs.Emit(outputs.Event{Type: "step.start", Machine: project.Name, Addr: project.Addr, Message: label})
+			return nil
+		},
+		StepHandler: func(step internal.StepLog) error {
+
+			status := "success"
+
+			if step.Error != "" {
+				status = "failed"
+			}
+
+			outputs.Emit(outputs.Event{
+				Type:     "step.finish",
+				Machine:  project.Name,
+				Addr:     project.Addr,
+				Status:   status,
+				Message:  step.Label,
+				Command:  step.Command,
+				ExitCode: &step.ExitCode,
+				Duration: step.Duration.Seconds(),
+				Error:    step.Error,
+			})
+			return nil
+		},
+		LineHandler: lines.Handler(project, ""),
+	}
+}
+
+// Set output format from output flag.
+func setOutputFormat(c *cli.Context) error {
+
+	switch c.String("output") {
+	case "", "text":
+		return nil
+	case "json":
+		outputs.JSONMode = true
+		return nil
+	}
+
+	return fmt.Errorf("Unknown output format: %s", c.String("output"))
+}
+
+// Emit final result event in json mode.
+func emitResult(e error, started time.Time) {
+
+	if !outputs.JSONMode {
+		return
+	}
+
+	status := "success"
+
+	if e != nil {
+		status = "failed"
+	}
+
+	outputs.Emit(outputs.Event{
+		Type:     "result",
+		Status:   status,
+		Duration: time.Since(started).Seconds(),
+		Error:    outputs.ErrorString(e),
+	})
+}
+
+// Serializes spinner output of machines deployed concurrently.
+var outputMu sync.Mutex
+
+// Get a spinner that writes nothing.
+func silentSpinner(msg string) *sp.Spinner {
+
+	s := sp.New(sp.CharSets[41], 100*time.Millisecond)
+	s.Suffix = msg
+	s.Writer = ioutil.Discard
+	s.Start()
+	return s
+}
+
+// Set spinner suffix, the spinner goroutine reads it while running.
+func setSuffix(sp *sp.Spinner, suffix string) {
+
+	sp.Lock()
+	sp.Suffix = suffix
+	sp.Unlock()
+}
+
+func progress(sp *sp.Spinner, prefix string) internal.ProgressHandler {
 
 	return func(log string) error {
 
-		sp.Suffix = " " + log
+		setSuffix(sp, " "+prefix+log)
 		return nil
 	}
 }
 
-func stdout(sp *sp.Spinner) internal.OutputHandler {
+func stdout(sp *sp.Spinner, prefix string) internal.OutputHandler {
 
 	return func(label string, log []byte) error {
 
-		sp.Stop()
+		outputMu.Lock()
+		defer outputMu.Unlock()
 
-		outputs.Success(label, "")
+		sp.Stop()
 
-		// TODO: redirect stdout to a file by a flag. default /dev/null
-		// if log := string(log); log != "" {
-		// 	fmt.Println(log)
-		// }
+		outputs.Success(prefix+label, "")
 
 		sp.Start()
 		return nil
 	}
 }
 
-func stderr(sp *sp.Spinner) internal.OutputHandler {
+func stderr(sp *sp.Spinner, prefix string) internal.OutputHandler {
 
 	return func(label string, log []byte) error {
 
+		outputMu.Lock()
+		defer outputMu.Unlock()
+
 		sp.Stop()
 
-		outputs.Error(label, "")
+		outputs.Error(prefix+label, "")
 
 		if log := string(log); log != "" {
 			fmt.Println(log)
@@ -393,3 +868,68 @@ func stderr(sp *sp.Spinner) internal.OutputHandler {
 		return nil
 	}
 }
+
+// Writes remote output lines to the terminal and/or a log file.
+type lineWriter struct {
+	mu      sync.Mutex
+	sp      *sp.Spinner
+	file    *os.File
+	verbose bool
+}
+
+func newLineWriter(sp *sp.Spinner, c *cli.Context) (w *lineWriter, e error) {
+
+	w = &lineWriter{
+		sp:      sp,
+		verbose: c.Bool("verbose"),
+	}
+
+	if c.String("log-file") != "" {
+		w.file, e = os.OpenFile(c.String("log-file"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	}
+
+	return
+}
+
+// Get line handler, it returns nil when there is nothing to write.
+func (w *lineWriter) Handler(project *internal.Project, prefix string) internal.OutputHandler {
+
+	if !w.verbose && w.file == nil {
+		return nil
+	}
+
+	return func(stream string, line []byte) error {
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if w.file != nil {
+			fmt.Fprintf(w.file, "%s %s%s: %s\n", time.Now().Format(time.RFC3339), prefix, stream, line)
+		}
+
+		if w.verbose && outputs.JSONMode {
+
+			outputs.Emit(outputs.Event{Type: "output", Machine: project.Name, Addr: project.Addr, Stream: stream, Output: string(line)})
+
+		} else if w.verbose {
+
+			outputMu.Lock()
+			defer outputMu.Unlock()
+
+			w.sp.Stop()
+			fmt.Printf("  %s%s\n", prefix, line)
+			w.sp.Start()
+		}
+
+		return nil
+	}
+}
+
+func (w *lineWriter) Close() error {
+
+	if w.file != nil {
+		return w.file.Close()
+	}
+
+	return nil
+}
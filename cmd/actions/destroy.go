@@ -0,0 +1,157 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package actions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/unleashable/apker/cmd/inputs"
+	"github.com/unleashable/apker/cmd/outputs"
+	"github.com/unleashable/apker/internal"
+	"github.com/urfave/cli/v2"
+)
+
+var DestroyFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "Destroy machines and images by name instead of project name.",
+	},
+	&cli.BoolFlag{
+		Name:  "all",
+		Usage: "Destroy all machines and images tagged by apker.",
+	},
+	&cli.BoolFlag{
+		Name:  "keep-images",
+		Usage: "Destroy only machines and keep custom images.",
+	},
+	&cli.BoolFlag{
+		Name:    "yes",
+		Aliases: []string{"y"},
+		Usage:   "Destroy without asking for confirmation.",
+	},
+}, MachineFlags...)
+
+func Destroy(c *cli.Context) (e error) {
+
+	var (
+		ok       bool
+		machines []internal.Machine
+		images   []internal.Image
+	)
+
+	project, provider, e := projectProvider(c)
+
+	if e != nil {
+		return
+	}
+
+	sp := outputs.Spinner(" Looking for resources...")
+
+	if machines, e = provider.List(internal.Tag); e == nil && !c.Bool("keep-images") {
+		images, e = provider.Images(internal.Tag)
+	}
+
+	sp.Stop()
+
+	if e != nil {
+		return
+	}
+
+	if !c.Bool("all") {
+		machines, images = filterByProject(project, machines, images)
+	}
+
+	if len(machines) == 0 && len(images) == 0 {
+
+		outputs.Success("Nothing to destroy.", "")
+		return
+	}
+
+	for _, m := range machines {
+		outputs.Error(fmt.Sprintf("Machine: %s (id: %d, addr: %s, region: %s)", m.Name, m.ID, m.Addr, m.Region), "-")
+	}
+
+	for _, i := range images {
+		outputs.Error(fmt.Sprintf("Image: %s (id: %d)", i.Name, i.ID), "-")
+	}
+
+	if !c.Bool("yes") && inputs.NonInteractive {
+
+		return errors.New("Use --yes to destroy in non-interactive mode.")
+
+	} else if !c.Bool("yes") {
+
+		ok, e = inputs.Confirm(fmt.Sprintf("Destroy %d machine(s) and %d image(s)", len(machines), len(images)))
+
+		if e != nil || !ok {
+			return
+		}
+	}
+
+	for _, m := range machines {
+
+		if e = provider.Destroy(m.ID); e != nil {
+			return fmt.Errorf("Destroy machine %d error: %s", m.ID, e.Error())
+		}
+
+		if e = project.State.UpdateAll(func(p *internal.ProjectState) {
+			p.RemoveMachine(m.ID)
+		}); e != nil {
+			return
+		}
+
+		outputs.Success(fmt.Sprintf("Machine destroyed: %s (%d)", m.Name, m.ID), "")
+	}
+
+	for _, i := range images {
+
+		if e = provider.DestroyImage(i.ID); e != nil {
+			return fmt.Errorf("Destroy image %d error: %s", i.ID, e.Error())
+		}
+
+		if e = project.State.UpdateAll(func(p *internal.ProjectState) {
+			p.RemoveImage(i.ID)
+		}); e != nil {
+			return
+		}
+
+		outputs.Success(fmt.Sprintf("Image destroyed: %s (%d)", i.Name, i.ID), "")
+	}
+
+	return
+}
+
+// Filter resources by project name or ids recorded in project state.
+func filterByProject(project *internal.Project, machines []internal.Machine, images []internal.Image) (m []internal.Machine, i []internal.Image) {
+
+	var (
+		state = project.State.Project(project.Name)
+		ids   = make(map[int]bool)
+	)
+
+	for _, machine := range state.Machines {
+		ids[machine.ID] = true
+	}
+
+	for _, machine := range machines {
+		if machine.Name == project.Name || ids[machine.ID] {
+			m = append(m, machine)
+		}
+	}
+
+	ids = make(map[int]bool)
+
+	for _, image := range state.Images {
+		ids[image.ID] = true
+	}
+
+	for _, image := range images {
+		if image.Name == project.Name || ids[image.ID] {
+			i = append(i, image)
+		}
+	}
+
+	return
+}
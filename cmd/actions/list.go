@@ -0,0 +1,151 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unleashable/apker/cmd/outputs"
+	"github.com/unleashable/apker/internal"
+	"github.com/urfave/cli/v2"
+)
+
+var ListFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Value:   "table",
+		Usage:   "Set output `format`: table or json.",
+	},
+}, MachineFlags...)
+
+func List(c *cli.Context) (e error) {
+
+	var machines []internal.Machine
+
+	_, provider, e := projectProvider(c)
+
+	if e != nil {
+		return
+	}
+
+	if machines, e = provider.List(internal.Tag); e != nil {
+		return
+	}
+
+	switch c.String("output") {
+	case "json":
+
+		if machines == nil {
+			machines = []internal.Machine{}
+		}
+
+		return outputs.JSON(machines)
+
+	case "table":
+
+		rows := [][]string{}
+
+		for _, m := range machines {
+			rows = append(rows, []string{strconv.Itoa(m.ID), m.Name, m.Addr, m.Region, m.Size, m.Status})
+		}
+
+		return outputs.Table([]string{"ID", "NAME", "ADDR", "REGION", "SIZE", "STATUS"}, rows)
+	}
+
+	return fmt.Errorf("Unknown output format: %s", c.String("output"))
+}
+
+func Status(c *cli.Context) (e error) {
+
+	var machine internal.Machine
+
+	project, provider, e := projectProvider(c)
+
+	if e != nil {
+		return
+	}
+
+	if machine, e = findMachine(provider, c.Args().First(), project.Name); e != nil {
+		return
+	}
+
+	deploy, _ := project.State.Project(project.StateName()).LastDeploy(machine.ID, machine.Addr)
+
+	switch c.String("output") {
+	case "json":
+		return outputs.JSON(struct {
+			internal.Machine
+			LastDeploy *internal.DeployRecord `json:"last_deploy,omitempty"`
+		}{machine, lastDeploy(deploy)})
+
+	case "table":
+		return outputs.Table([]string{"PROPERTY", "VALUE"}, [][]string{
+			{"ID", strconv.Itoa(machine.ID)},
+			{"Name", machine.Name},
+			{"Status", machine.Status},
+			{"Addr", machine.Addr},
+			{"Region", machine.Region},
+			{"Size", machine.Size},
+			{"Image", machine.Image},
+			{"Tags", strings.Join(machine.Tags, ", ")},
+			{"Created", machine.Created},
+			{"Last deploy", deployString(deploy)},
+		})
+	}
+
+	return fmt.Errorf("Unknown output format: %s", c.String("output"))
+}
+
+// Find apker machine by id or name, fallback to project name.
+func findMachine(provider internal.Provider, query string, name string) (m internal.Machine, e error) {
+
+	if id, err := strconv.Atoi(query); err == nil {
+		return provider.Get(id)
+	}
+
+	if query == "" {
+		query = name
+	}
+
+	machines, e := provider.List(internal.Tag)
+
+	if e != nil {
+		return
+	}
+
+	for _, m = range machines {
+		if m.Name == query {
+			return
+		}
+	}
+
+	e = errors.New("Machine not found: " + query)
+	return
+}
+
+func lastDeploy(r internal.DeployRecord) *internal.DeployRecord {
+
+	if r.Started.IsZero() {
+		return nil
+	}
+
+	return &r
+}
+
+func deployString(r internal.DeployRecord) string {
+
+	switch {
+	case r.Started.IsZero():
+		return "-"
+	case r.Success:
+		return fmt.Sprintf("%s succeeded (%s)", r.Finished.Format(time.RFC1123), r.Finished.Sub(r.Started).Round(time.Second))
+	}
+
+	return fmt.Sprintf("%s failed: %s", r.Finished.Format(time.RFC1123), r.Error)
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/unleashable/apker/cmd/outputs"
+	"github.com/unleashable/apker/internal"
+	"github.com/urfave/cli/v2"
+)
+
+var LogsFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Value:   "table",
+		Usage:   "Set output `format`: table or json.",
+	},
+	&cli.StringFlag{
+		Name:  "project",
+		Usage: "Show only runs of project `name`.",
+	},
+}
+
+func Logs(c *cli.Context) (e error) {
+
+	if c.Args().First() != "" {
+		return showRunLog(c, c.Args().First())
+	}
+
+	runs, e := internal.ListRunLogs()
+
+	if e != nil {
+		return
+	}
+
+	filtered := []*internal.RunLog{}
+
+	for _, r := range runs {
+		if c.String("project") == "" || r.Project == c.String("project") {
+			filtered = append(filtered, r)
+		}
+	}
+
+	switch c.String("output") {
+	case "json":
+		return outputs.JSON(filtered)
+
+	case "table":
+
+		rows := [][]string{}
+
+		for _, r := range filtered {
+			rows = append(rows, []string{
+				r.ID,
+				r.Project,
+				r.Machine,
+				r.Addr,
+				r.Started.Format(time.RFC1123),
+				runDuration(r),
+				runStatus(r),
+			})
+		}
+
+		return outputs.Table([]string{"ID", "PROJECT", "MACHINE", "ADDR", "STARTED", "DURATION", "STATUS"}, rows)
+	}
+
+	return fmt.Errorf("Unknown output format: %s", c.String("output"))
+}
+
+func showRunLog(c *cli.Context, id string) (e error) {
+
+	r, e := internal.LoadRunLog(id)
+
+	if e != nil {
+		return fmt.Errorf("Run %s not found: %s", id, e.Error())
+	}
+
+	if c.String("output") == "json" {
+		return outputs.JSON(r)
+	}
+
+	fmt.Printf("Run:      %s\n", r.ID)
+	fmt.Printf("Project:  %s\n", r.Project)
+	fmt.Printf("Machine:  %s (%s)\n", r.Machine, r.Addr)
+	fmt.Printf("Repo:     %s\n", r.Repo)
+
+	if r.Ref != "" {
+		fmt.Printf("Ref:      %s\n", r.Ref)
+	}
+
+	if r.Commit != "" {
+		fmt.Printf("Commit:   %s\n", r.Commit)
+	}
+
+	fmt.Printf("Started:  %s\n", r.Started.Format(time.RFC1123))
+	fmt.Printf("Duration: %s\n", runDuration(r))
+	fmt.Printf("Status:   %s\n", runStatus(r))
+
+	for _, step := range r.Steps {
+
+		fmt.Println("")
+
+		if step.ExitCode == 0 {
+			outputs.Success(step.Label, "")
+		} else {
+			outputs.Error(step.Label, "")
+		}
+
+		fmt.Printf("  Command:   %s\n", step.Command)
+		fmt.Printf("  Exit code: %d\n", step.ExitCode)
+		fmt.Printf("  Started:   %s\n", step.Started.Format(time.RFC3339))
+		fmt.Printf("  Duration:  %s\n", step.Duration.Round(time.Millisecond))
+
+		printLog("Stdout", step.Stdout)
+		printLog("Stderr", step.Stderr)
+	}
+
+	return
+}
+
+func printLog(name string, log string) {
+
+	if log = strings.TrimRight(log, "\n"); log == "" {
+		return
+	}
+
+	fmt.Printf("  %s:\n", name)
+
+	for _, line := range strings.Split(log, "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+func runDuration(r *internal.RunLog) string {
+
+	if r.Finished.IsZero() {
+		return "-"
+	}
+
+	return r.Finished.Sub(r.Started).Round(time.Second).String()
+}
+
+func runStatus(r *internal.RunLog) string {
+
+	switch {
+	case r.Finished.IsZero():
+		return "running"
+	case r.Success:
+		return "success"
+	}
+
+	return "failed"
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package actions
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/unleashable/apker/cmd/outputs"
+	. "github.com/unleashable/apker/cmd/utils"
+	"github.com/unleashable/apker/internal"
+	"github.com/urfave/cli/v2"
+)
+
+var MachineFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "url",
+		Aliases: []string{"repo"},
+		Usage:   "Set project git repository url.",
+	},
+	&cli.StringSliceFlag{
+		Name:    "parameter",
+		Usage:   "Set deploy template parameters.",
+		Aliases: []string{"set"},
+	},
+}
+
+func PowerOff(c *cli.Context) error {
+
+	return machineAction(c, "Powering off", func(p internal.Provider, id int) error {
+		return p.PowerOff(id)
+	})
+}
+
+func PowerOn(c *cli.Context) error {
+
+	return machineAction(c, "Powering on", func(p internal.Provider, id int) error {
+		return p.PowerOn(id)
+	})
+}
+
+func PowerCycle(c *cli.Context) error {
+
+	return machineAction(c, "Power cycling", func(p internal.Provider, id int) error {
+		return p.PowerCycle(id)
+	})
+}
+
+func Resize(c *cli.Context) error {
+
+	size := c.Args().Get(1)
+
+	if size == "" {
+		return errors.New("Please set the new machine size: apker machine resize <id> <size>")
+	}
+
+	return machineAction(c, "Resizing", func(p internal.Provider, id int) error {
+		return p.Resize(id, size)
+	})
+}
+
+func Snapshot(c *cli.Context) error {
+
+	name := c.Args().Get(1)
+
+	return machineAction(c, "Taking snapshot of", func(p internal.Provider, id int) error {
+
+		m, e := p.Get(id)
+
+		if e != nil {
+			return e
+		}
+
+		if name == "" {
+			name = m.Name + "-snapshot"
+		}
+
+		return p.Snapshot(id, name)
+	})
+}
+
+func machineAction(c *cli.Context, label string, action func(internal.Provider, int) error) (e error) {
+
+	id, e := strconv.Atoi(c.Args().First())
+
+	if e != nil {
+		return errors.New("Please set a valid machine id.")
+	}
+
+	_, provider, e := projectProvider(c)
+
+	if e != nil {
+		return
+	}
+
+	sp := outputs.Spinner(" " + label + " machine " + c.Args().First() + "...")
+
+	e = action(provider, id)
+
+	sp.Stop()
+
+	if e == nil {
+		outputs.Success("Done: "+label+" machine "+c.Args().First(), "")
+	}
+
+	return
+}
+
+// Resolve provider of the current project.
+func projectProvider(c *cli.Context) (project *internal.Project, provider internal.Provider, e error) {
+
+	project, e = NewProject(c)
+
+	if project != nil {
+		defer os.RemoveAll(project.Temp)
+	}
+
+	if e != nil {
+		return
+	}
+
+	provider, _, e = internal.NewProvider(project)
+	return
+}
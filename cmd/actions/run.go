@@ -4,27 +4,68 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/melbahja/goph"
-	"github.com/unleashable/apker/cmd/inputs"
+	"github.com/unleashable/apker/cmd/outputs"
+	. "github.com/unleashable/apker/cmd/utils"
+	"github.com/unleashable/apker/internal"
+	"github.com/unleashable/apker/internal/utils"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/crypto/ssh"
 )
 
 var RunFlags = []cli.Flag{
-	&cli.StringFlag{
-		Name:     "addr",
-		Aliases:  []string{"ip"},
-		Usage:    "Set machine `ip` address.",
-		Required: true,
+	&cli.StringSliceFlag{
+		Name:    "addr",
+		Aliases: []string{"ip"},
+		Usage:   "Set machine `ip` address, can be repeated. (default: project machine)",
 	},
 	&cli.StringFlag{
 		Name:  "user",
-		Value: "root",
-		Usage: "Set ssh `user` name.",
+		Usage: "Set ssh `user` name. (default: deploy user or root)",
+	},
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "Set machine name, defaults to the project machine name.",
+	},
+	&cli.StringFlag{
+		Name:  "tag",
+		Usage: "Run on all provider machines with this `tag`.",
+	},
+	&cli.BoolFlag{
+		Name:  "all",
+		Usage: "Run on all project machines recorded in state. (default: last deployed machine)",
+	},
+	&cli.StringFlag{
+		Name:  "hosts",
+		Usage: "Run on machines listed in hosts `file`, one [user@]addr per line.",
+	},
+	&cli.IntFlag{
+		Name:  "parallel",
+		Value: 10,
+		Usage: "Set max number of machines to run on concurrently.",
+	},
+	&cli.StringFlag{
+		Name:    "url",
+		Aliases: []string{"repo"},
+		Usage:   "Set project git repository url.",
+	},
+	&cli.StringSliceFlag{
+		Name:    "parameter",
+		Usage:   "Set deploy template parameters.",
+		Aliases: []string{"set"},
+	},
+	&cli.StringFlag{
+		Name:  "jump",
+		Usage: "Connect through jump `hosts`: comma separated [user@]host[:port]. (default: apker.yaml ssh.jump)",
 	},
 	&cli.StringFlag{
 		Name:  "knownhosts",
@@ -35,6 +76,14 @@ var RunFlags = []cli.Flag{
 		Name:  "passphrase",
 		Usage: "Ask for private key passphrase for protected keys.",
 	},
+	&cli.StringFlag{
+		Name:  "password-file",
+		Usage: "Read ssh password from `file` (or set APKER_PASSWORD).",
+	},
+	&cli.StringFlag{
+		Name:  "passphrase-file",
+		Usage: "Read private key passphrase from `file` (or set APKER_PASSPHRASE).",
+	},
 	&cli.BoolFlag{
 		Name:  "agent",
 		Usage: "Use ssh agent.",
@@ -43,6 +92,12 @@ var RunFlags = []cli.Flag{
 		Name:  "password",
 		Usage: "Ask for ssh password instead of using private keys.",
 	},
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Value:   "text",
+		Usage:   "Set output `format`: text or json (newline delimited events).",
+	},
 	&cli.StringSliceFlag{
 		Name:    "env",
 		Usage:   "Set action env variables: (`NAME=VALUE`).",
@@ -50,63 +105,332 @@ var RunFlags = []cli.Flag{
 	},
 }
 
+// Remote machine to run the action on.
+type target struct {
+	User string
+	Addr string
+	Jump string
+}
+
+type runResult struct {
+	Output   []byte
+	Error    error
+	Duration time.Duration
+}
+
 func Run(c *cli.Context) (e error) {
 
 	var (
-		cmd      string = fmt.Sprintf("/usr/share/apker/bin/%s", c.Args().First())
-		pass     string
-		auth     goph.Auth
-		client   *goph.Client
-		output   []byte
+		cmd      string = "/usr/share/apker/bin/" + c.Args().First()
+		sshAuth  SSHAuth
+		targets  []target
 		callback ssh.HostKeyCallback
+		started  time.Time = time.Now()
 	)
 
+	if e = setOutputFormat(c); e != nil {
+		return
+	}
+
 	if callback, e = goph.KnownHosts(c.String("knownhosts")); e != nil {
 		return
 	}
 
-	if c.Bool("password") {
+	if targets, e = resolveTargets(c); e != nil {
+		return
+	}
 
-		pass, e = inputs.Password("Enter ssh password", func(pass string) error {
-			return nil
-		})
+	if sshAuth, e = ResolveAuth(c); e != nil {
+		return
+	}
 
-		if e != nil {
+	// Run the action.
+	vars, e := env(c.StringSlice("env"))
+
+	if e != nil {
+		return
+	}
+
+	cmd = fmt.Sprintf("env %s %s", vars, utils.ShellQuote(cmd))
+
+	if len(targets) == 1 && !outputs.JSONMode {
+
+		output, e := runOn(targets[0], sshAuth.Methods, callback, cmd)
+
+		fmt.Println("")
+		fmt.Println(string(output))
+
+		return e
+	}
+
+	var (
+		mu      sync.Mutex
+		failed  int
+		results = make([]runResult, len(targets))
+	)
+
+	utils.Parallel(len(targets), c.Int("parallel"), func(i int) {
+
+		start := time.Now()
+		output, err := runOn(targets[i], sshAuth.Methods, callback, cmd)
+		results[i] = runResult{output, err, time.Since(start)}
+
+		if outputs.JSONMode {
+			emitRunResult(targets[i], results[i])
 			return
 		}
 
-		auth = goph.Password(pass)
+		// Print host output as soon as it's done.
+		mu.Lock()
+		defer mu.Unlock()
 
-	} else if c.Bool("agent") {
+		for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+			fmt.Printf("[%s] %s\n", targets[i].Addr, line)
+		}
+	})
+
+	if !outputs.JSONMode {
+		fmt.Println("")
+	}
 
-		auth = goph.UseAgent()
+	for i, r := range results {
 
-	} else {
+		if r.Error != nil {
 
-		if c.Bool("passphrase") {
+			failed++
 
-			pass, e = inputs.Password("Enter private key passphrase", func(pass string) error {
-				return nil
-			})
+			if !outputs.JSONMode {
+				outputs.Error(fmt.Sprintf("%s: %s", targets[i].Addr, r.Error.Error()), "")
+			}
+
+			continue
+		}
+
+		if !outputs.JSONMode {
+			outputs.Success(targets[i].Addr, "")
 		}
+	}
 
-		auth = goph.Key(c.String("key"), pass)
+	if failed > 0 {
+		e = fmt.Errorf("Action failed on %d of %d machines.", failed, len(targets))
 	}
 
-	// Get ssh client.
-	if client, e = goph.NewConn(c.String("user"), c.String("addr"), auth, callback); e != nil {
+	emitResult(e, started)
+	return
+}
+
+func emitRunResult(t target, r runResult) {
+
+	var (
+		code    int
+		status  string = "success"
+		exitErr *ssh.ExitError
+	)
+
+	if errors.As(r.Error, &exitErr) {
+		code = exitErr.ExitStatus()
+	} else if r.Error != nil {
+		code = -1
+	}
+
+	if r.Error != nil {
+		status = "failed"
+	}
+
+	outputs.Emit(outputs.Event{
+		Type:     "run.result",
+		Addr:     t.Addr,
+		Status:   status,
+		Output:   string(r.Output),
+		ExitCode: &code,
+		Duration: r.Duration.Seconds(),
+		Error:    outputs.ErrorString(r.Error),
+	})
+}
+
+func runOn(t target, auth goph.Auth, callback ssh.HostKeyCallback, cmd string) (_ []byte, e error) {
+
+	client := &goph.Client{Port: 22, Addr: t.Addr, User: t.User, Auth: auth}
+	jump := internal.Jump{Hosts: t.Jump, Auth: auth, Callback: callback}
+
+	client.Conn, e = jump.Client(net.JoinHostPort(t.Addr, "22"), &ssh.ClientConfig{
+		User:            t.User,
+		Auth:            auth,
+		Timeout:         20 * time.Second,
+		HostKeyCallback: callback,
+	})
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer client.Close()
+
+	return client.Run(cmd)
+}
+
+// Resolve target machines from flags, hosts file, provider tag, or the project machines.
+func resolveTargets(c *cli.Context) (targets []target, e error) {
+
+	var (
+		data     []byte
+		addrs    = c.StringSlice("addr")
+		project  *internal.Project
+		provider internal.Provider
+		machines []internal.Machine
+	)
+
+	defer func() {
+		for i := range targets {
+			if targets[i].User == "" {
+				targets[i].User = c.String("user")
+			}
+			if targets[i].User == "" {
+				targets[i].User = "root"
+			}
+			if targets[i].Jump == "" {
+				targets[i].Jump = c.String("jump")
+			}
+			if targets[i].Jump == "" && project != nil {
+				targets[i].Jump = project.Jump
+			}
+		}
+	}()
+
+	if c.String("hosts") != "" {
+
+		if data, e = ioutil.ReadFile(c.String("hosts")); e != nil {
+			return
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				addrs = append(addrs, line)
+			}
+		}
+	}
+
+	for _, addr := range addrs {
+		targets = append(targets, parseTarget(addr))
+	}
+
+	if len(targets) > 0 && c.String("tag") == "" {
 		return
 	}
 
-	// Run the action.
-	output, e = client.Run(fmt.Sprintf(`env %s bash -c '%s'`, env(c.StringSlice("env")), cmd))
+	project, e = NewProject(c)
+
+	if project != nil {
+		defer os.RemoveAll(project.Temp)
+	}
+
+	if e != nil {
+		return
+	}
+
+	// Last deployed machine, or all project machines recorded in state.
+	if c.String("tag") == "" {
+
+		state := project.State.Project(project.Name)
+		recorded := state.Machines
+
+		if !c.Bool("all") {
+
+			recorded = nil
+
+			if m, ok := state.DeployedMachine(); ok {
+				recorded = append(recorded, m)
+			}
+		}
+
+		for _, m := range recorded {
+
+			if m.Addr == "" {
+				continue
+			}
+
+			t := target{Addr: m.Addr, Jump: project.Jump}
+
+			// User flag overrides deploy user.
+			if c.String("user") == "" {
+				t.User = m.User
+			}
+
+			targets = append(targets, t)
+		}
+
+		if len(targets) > 0 {
+			return
+		}
+	}
+
+	if provider, _, e = internal.NewProvider(project); e != nil {
+		return
+	}
+
+	if c.String("tag") == "" {
+
+		m, e := findMachine(provider, "", project.Name)
+
+		if e != nil {
+			return nil, e
+		}
+
+		machines = append(machines, m)
+
+	} else if machines, e = provider.List(c.String("tag")); e != nil {
+
+		return
+
+	} else if len(machines) == 0 {
+
+		return nil, fmt.Errorf("No machines found with tag: %s", c.String("tag"))
+	}
+
+	for _, m := range machines {
+
+		if m.Addr == "" {
+			return nil, fmt.Errorf("Machine %s has no ip address yet.", m.Name)
+		}
 
-	fmt.Println("")
-	fmt.Println(string(output))
+		targets = append(targets, target{Addr: m.Addr, Jump: project.Jump})
+	}
+
+	return
+}
+
+func parseTarget(s string) target {
+
+	if i := strings.LastIndex(s, "@"); i != -1 {
+		return target{User: s[:i], Addr: s[i+1:]}
+	}
 
-	return e
+	return target{Addr: s}
 }
 
-func env(s []string) string {
-	return strings.Join(append(s, "APKER_ACTION=1"), " ")
+// Get quoted env assignments from NAME=VALUE strings.
+func env(s []string) (_ string, e error) {
+
+	var (
+		v    string
+		vars []string
+	)
+
+	for _, v = range append(s, "APKER_ACTION=1") {
+
+		parts := strings.SplitN(v, "=", 2)
+
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+
+		if v, e = utils.ShellEnv(parts[0], parts[1]); e != nil {
+			return
+		}
+
+		vars = append(vars, v)
+	}
+
+	return strings.Join(vars, " "), nil
 }
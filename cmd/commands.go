@@ -22,4 +22,72 @@ var Commands = []*cli.Command{
 		Action: actions.Run,
 		Flags:  actions.RunFlags,
 	},
+	{
+		Name:   "destroy",
+		Usage:  "Destroy project machines and custom images.",
+		Action: actions.Destroy,
+		Flags:  actions.DestroyFlags,
+	},
+	{
+		Name:    "list",
+		Aliases: []string{"ls"},
+		Usage:   "List machines created by apker.",
+		Action:  actions.List,
+		Flags:   actions.ListFlags,
+	},
+	{
+		Name:      "status",
+		Usage:     "Show machine status.",
+		ArgsUsage: "[name|id]",
+		Action:    actions.Status,
+		Flags:     actions.ListFlags,
+	},
+	{
+		Name:      "logs",
+		Usage:     "Show past deployment runs logs.",
+		ArgsUsage: "[run-id]",
+		Action:    actions.Logs,
+		Flags:     actions.LogsFlags,
+	},
+	{
+		Name:  "machine",
+		Usage: "Manage machines created by apker.",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "poweroff",
+				Usage:     "Power off a machine.",
+				ArgsUsage: "<id>",
+				Action:    actions.PowerOff,
+				Flags:     actions.MachineFlags,
+			},
+			{
+				Name:      "poweron",
+				Usage:     "Power on a machine.",
+				ArgsUsage: "<id>",
+				Action:    actions.PowerOn,
+				Flags:     actions.MachineFlags,
+			},
+			{
+				Name:      "powercycle",
+				Usage:     "Power cycle a machine.",
+				ArgsUsage: "<id>",
+				Action:    actions.PowerCycle,
+				Flags:     actions.MachineFlags,
+			},
+			{
+				Name:      "resize",
+				Usage:     "Resize a machine, it should be powered off.",
+				ArgsUsage: "<id> <size>",
+				Action:    actions.Resize,
+				Flags:     actions.MachineFlags,
+			},
+			{
+				Name:      "snapshot",
+				Usage:     "Take a snapshot image of a machine.",
+				ArgsUsage: "<id> [name]",
+				Action:    actions.Snapshot,
+				Flags:     actions.MachineFlags,
+			},
+		},
+	},
 }
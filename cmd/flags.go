@@ -4,8 +4,8 @@
 package cmd
 
 import (
+	"github.com/unleashable/apker/cmd/inputs"
 	"github.com/urfave/cli/v2"
-	"os"
 )
 
 // Global cli flags
@@ -13,13 +13,22 @@ var Flags = []cli.Flag{
 	&cli.StringFlag{
 		Name:    "pub",
 		Aliases: []string{"p"},
-		Value:   os.ExpandEnv("$HOME/.ssh/id_rsa.pub"),
-		Usage:   "Set ssh public key `path`",
+		Usage:   "Set ssh public key `path` to register with providers. (default: private key public key)",
 	},
 	&cli.StringFlag{
 		Name:    "key",
 		Aliases: []string{"i"},
-		Value:   os.ExpandEnv("$HOME/.ssh/id_rsa"),
-		Usage:   "Set ssh private key `path`",
+		Usage:   "Set ssh private key `path`, its key-cert.pub certificate is used when found. (default: ~/.ssh/id_ed25519, id_ecdsa or id_rsa)",
 	},
+	&cli.BoolFlag{
+		Name:  "non-interactive",
+		Usage: "Never prompt for input, fail when a value is missing (default when CI=true or no terminal).",
+	},
+}
+
+// Run before any command.
+func Before(c *cli.Context) error {
+
+	inputs.DetectNonInteractive(c.Bool("non-interactive"))
+	return nil
 }
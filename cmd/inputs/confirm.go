@@ -0,0 +1,28 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package inputs
+
+import (
+	"github.com/melbahja/promptui"
+)
+
+func Confirm(label string) (bool, error) {
+
+	if NonInteractive {
+		return false, ErrNonInteractive
+	}
+
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+	}
+
+	_, e := prompt.Run()
+
+	if e == promptui.ErrAbort {
+		return false, nil
+	}
+
+	return e == nil, e
+}
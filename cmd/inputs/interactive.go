@@ -0,0 +1,23 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package inputs
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// When true, inputs fail instead of prompting the user.
+var NonInteractive bool
+
+var ErrNonInteractive = errors.New("Can't prompt for input in non-interactive mode.")
+
+// Detect non-interactive mode from flag value, CI env var, or a missing terminal.
+func DetectNonInteractive(flag bool) bool {
+
+	NonInteractive = flag || os.Getenv("CI") == "true" || !terminal.IsTerminal(int(os.Stdin.Fd()))
+	return NonInteractive
+}
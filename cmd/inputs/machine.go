@@ -4,51 +4,52 @@
 package inputs
 
 import (
-	"context"
 	"fmt"
 
 	"github.com/melbahja/promptui"
-	"github.com/unleashable/apker/internal/providers"
+	"github.com/unleashable/apker/internal"
 )
 
-func SetDropletSize(do *providers.Digitalocean, size string) error {
+func SetMachineSize(project *internal.Project, catalog internal.Catalog, size string) error {
 
 	if size == "" {
-		size = do.Project.Config.Image.Size
+		size = project.Config.Image.Size
 	}
 
 	switch size {
 
 	case "":
-		return DigitaloceanSelectSize(do)
+		return SelectSize(project, catalog)
 
 	case "small":
-		do.Project.Config.Image.Size = "s-1vcpu-1gb"
+		project.Config.Image.Size = "s-1vcpu-1gb"
 		break
 
 	default:
-		do.Project.Config.Image.Size = size
+		project.Config.Image.Size = size
 	}
 
 	return nil
 }
 
-func SetDropletRegion(do *providers.Digitalocean, region string) error {
+func SetMachineRegion(project *internal.Project, catalog internal.Catalog, region string) error {
 
 	if region != "" {
 
-		do.Project.Config.Image.Region = region
+		project.Config.Image.Region = region
 		return nil
 	}
 
-	return DigitaloceanSelectRegion(do)
+	return SelectRegion(project, catalog)
 }
 
-func DigitaloceanSelectSize(do *providers.Digitalocean) error {
+func SelectSize(project *internal.Project, catalog internal.Catalog) error {
 
-	ctx := context.TODO()
+	if NonInteractive {
+		return ErrNonInteractive
+	}
 
-	sizes, _, e := do.DoClient.Sizes.List(ctx, nil)
+	sizes, e := catalog.Sizes()
 
 	if e != nil {
 		return e
@@ -80,22 +81,27 @@ func DigitaloceanSelectSize(do *providers.Digitalocean) error {
 		return e
 	}
 
-	do.Project.Config.Image.Size = sizes[i].Slug
+	project.Config.Image.Size = sizes[i].Slug
 
 	return nil
 }
 
-func DigitaloceanSelectRegion(do *providers.Digitalocean) error {
+func SelectRegion(project *internal.Project, catalog internal.Catalog) error {
 
-	ctx := context.TODO()
+	if NonInteractive {
+		return ErrNonInteractive
+	}
 
-	regions, _, e := do.DoClient.Regions.List(ctx, nil)
+	regions, e := catalog.Regions()
 
 	if e != nil {
 		return e
 	}
 
-	var items []string
+	var (
+		items     []string
+		available []internal.Region
+	)
 
 	for _, r := range regions {
 
@@ -104,6 +110,7 @@ func DigitaloceanSelectRegion(do *providers.Digitalocean) error {
 		}
 
 		items = append(items, r.Name)
+		available = append(available, r)
 	}
 
 	prompt := promptui.Select{
@@ -120,7 +127,7 @@ func DigitaloceanSelectRegion(do *providers.Digitalocean) error {
 		return e
 	}
 
-	do.Project.Config.Image.Region = regions[i].Slug
+	project.Config.Image.Region = available[i].Slug
 
 	return nil
 }
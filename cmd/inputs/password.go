@@ -15,6 +15,10 @@ func Password(label string, validator func(string) error) (string, error) {
 		spass string
 	)
 
+	if NonInteractive {
+		return "", ErrNonInteractive
+	}
+
 	for {
 
 		outputs.Input(label, "")
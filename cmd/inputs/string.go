@@ -9,6 +9,10 @@ import (
 
 func AskString(label string, def string) (v string, e error) {
 
+	if NonInteractive {
+		return "", ErrNonInteractive
+	}
+
 	prompt := promptui.Prompt{
 		Label: label,
 	}
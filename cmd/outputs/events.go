@@ -0,0 +1,54 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package outputs
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// When true, outputs are written as newline delimited json events.
+var JSONMode bool
+
+var eventsMu sync.Mutex
+
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Machine  string    `json:"machine,omitempty"`
+	Addr     string    `json:"addr,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Command  string    `json:"command,omitempty"`
+	Stream   string    `json:"stream,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Write event as a json line to stdout.
+func Emit(e Event) {
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	json.NewEncoder(os.Stdout).Encode(e)
+}
+
+// Get error message or empty string for nil errors.
+func ErrorString(e error) string {
+
+	if e == nil {
+		return ""
+	}
+
+	return e.Error()
+}
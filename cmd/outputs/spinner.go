@@ -4,6 +4,7 @@
 package outputs
 
 import (
+	"io/ioutil"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -13,6 +14,11 @@ func Spinner(msg string) *spinner.Spinner {
 
 	s := spinner.New(spinner.CharSets[41], 100*time.Millisecond)
 	s.Suffix = msg
+
+	if JSONMode {
+		s.Writer = ioutil.Discard
+	}
+
 	s.Start()
 	return s
 }
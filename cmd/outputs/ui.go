@@ -8,6 +8,11 @@ import (
 
 func Success(msg string, icon string) {
 
+	if JSONMode {
+		Emit(Event{Type: "message", Status: "success", Message: msg})
+		return
+	}
+
 	if icon == "" {
 		icon = "✔"
 	}
@@ -26,6 +31,11 @@ func Input(msg string, icon string) {
 
 func Error(msg string, icon string) {
 
+	if JSONMode {
+		Emit(Event{Type: "message", Status: "error", Message: msg})
+		return
+	}
+
 	if icon == "" {
 		icon = "✗"
 	}
@@ -1,66 +1,191 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"strings"
 
 	"github.com/melbahja/goph"
 	"github.com/unleashable/apker/cmd/inputs"
 	"github.com/unleashable/apker/internal"
 	"github.com/unleashable/apker/internal/utils"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
 )
 
-func SetAuthMethod(project *internal.Project, c *cli.Context) (e error) {
+// Resolved ssh auth, shared by deploy and run commands.
+type SSHAuth struct {
+	Methods goph.Auth
 
-	var pass string
+	// Public key to register with providers, nil with password auth.
+	PublicKey     ssh.PublicKey
+	PublicKeyPath string
 
-	if c.Bool("password") {
+	PrivateKeyPath string
+}
 
-		pass, e = inputs.Password("Enter ssh password", func(p string) error {
+// Resolve ssh auth from flags: password, ssh agent, or private key
+// (default: ~/.ssh/id_ed25519, id_ecdsa or id_rsa) and its certificate.
+func ResolveAuth(c *cli.Context) (auth SSHAuth, e error) {
 
-			if len(p) < 1 {
-				return fmt.Errorf("Invalid password!")
-			}
+	var (
+		pass    string
+		signers []ssh.Signer
+	)
 
-			return nil
-		})
+	switch {
+	case c.Bool("password") || c.String("password-file") != "":
 
-		if e != nil {
+		if pass, e = password(c); e != nil {
 			return
 		}
 
-		fmt.Println("")
-		project.SSHAuth = goph.Password(pass)
+		auth.Methods = goph.Password(pass)
 		return
+
+	case c.Bool("agent"):
+
+		if signers, e = utils.AgentSigners(); e != nil {
+			return
+		} else if len(signers) == 0 {
+			return auth, errors.New("No keys found in ssh agent, add one with ssh-add.")
+		}
+
+	default:
+
+		if auth.PrivateKeyPath = c.String("key"); auth.PrivateKeyPath == "" {
+
+			if auth.PrivateKeyPath, e = utils.FindSSHKey(); e != nil {
+				return
+			}
+		}
+
+		if pass, e = passphrase(c, auth.PrivateKeyPath); e != nil {
+			return
+		}
+
+		if signers, e = utils.KeySigners(auth.PrivateKeyPath, pass); e != nil {
+			return
+		}
 	}
 
-	project.PublicKey.Fingerprint,
-		project.PublicKey.Path,
-		project.PrivateKey.Path,
-		e = utils.ResolveSSHKeys(c.String("pub"), c.String("key"))
+	auth.Methods = goph.Auth{ssh.PublicKeys(signers...)}
+	auth.PublicKey = utils.PlainKey(signers[0].PublicKey())
+
+	// Public key file overrides the registered key.
+	if auth.PublicKeyPath = c.String("pub"); auth.PublicKeyPath != "" {
+		auth.PublicKey, e = readPublicKey(auth.PublicKeyPath)
+	}
+
+	return
+}
+
+// Set project ssh auth from flags.
+func SetAuthMethod(project *internal.Project, c *cli.Context) (e error) {
+
+	auth, e := ResolveAuth(c)
 
 	if e != nil {
 		return
 	}
 
-	if c.Bool("passphrase") {
+	project.SSHAuth = auth.Methods
+	project.PublicKey.Path = auth.PublicKeyPath
+	project.PrivateKey.Path = auth.PrivateKeyPath
 
-		pass, e = inputs.Password("Enter private key passphrase", func(p string) error {
+	if auth.PublicKey != nil {
+		project.PublicKey.Fingerprint = ssh.FingerprintLegacyMD5(auth.PublicKey)
+		project.PublicKey.Key = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(auth.PublicKey)))
+	}
 
-			if utils.IsValidPassphrase(project.PrivateKey.Path, p) {
-				return nil
-			}
+	return
+}
 
-			return fmt.Errorf("Invalid passphrase!")
-		})
+func password(c *cli.Context) (pass string, e error) {
 
-		if e != nil {
-			return
+	var ok bool
+
+	if pass, ok, e = Secret(c, "password-file", "APKER_PASSWORD"); e != nil || ok {
+		return
+	}
+
+	pass, e = inputs.Password("Enter ssh password", func(p string) error {
+
+		if len(p) < 1 {
+			return fmt.Errorf("Invalid password!")
 		}
 
-		fmt.Println("")
+		return nil
+	})
+
+	if e == inputs.ErrNonInteractive {
+		return "", errors.New("SSH password is required, set APKER_PASSWORD or --password-file.")
+	} else if e != nil {
+		return
+	}
+
+	fmt.Println("")
+	return
+}
+
+// Get private key passphrase, asked when the key is encrypted.
+func passphrase(c *cli.Context, key string) (pass string, e error) {
+
+	var ok bool
+
+	if pass, ok, e = Secret(c, "passphrase-file", "APKER_PASSPHRASE"); e != nil {
+
+		return
+
+	} else if ok {
+
+		if !utils.IsValidPassphrase(key, pass) {
+			return "", fmt.Errorf("Invalid passphrase!")
+		}
+
+		return
+
+	} else if !c.Bool("passphrase") && !utils.IsEncryptedKey(key) {
+
+		return
 	}
 
-	project.SSHAuth = goph.Key(project.PrivateKey.Path, pass)
+	pass, e = inputs.Password("Enter private key passphrase", func(p string) error {
+
+		if utils.IsValidPassphrase(key, p) {
+			return nil
+		}
+
+		return fmt.Errorf("Invalid passphrase!")
+	})
+
+	if e == inputs.ErrNonInteractive {
+		return "", errors.New("Private key passphrase is required, set APKER_PASSPHRASE or --passphrase-file.")
+	} else if e != nil {
+		return
+	}
+
+	fmt.Println("")
 	return
 }
+
+func readPublicKey(file string) (ssh.PublicKey, error) {
+
+	data, e := ioutil.ReadFile(file)
+
+	if e != nil {
+		return nil, e
+	}
+
+	key, _, _, _, e := ssh.ParseAuthorizedKey(data)
+
+	if e != nil {
+		return nil, fmt.Errorf("Invalid public key %s: %s", file, e.Error())
+	}
+
+	return utils.PlainKey(key), nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/unleashable/apker/internal"
+	"github.com/unleashable/apker/internal/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// Init new project from cli flags and load its apker.yaml config,
+// the caller should remove project.Temp when done.
+func NewProject(c *cli.Context) (*internal.Project, error) {
+
+	return newProject(c, false)
+}
+
+// Init deploy project, its ref is resolved to a commit first so apker.yaml
+// and all machines use the same commit, even if the branch moves.
+func NewDeployProject(c *cli.Context) (*internal.Project, error) {
+
+	return newProject(c, true)
+}
+
+func newProject(c *cli.Context, deploy bool) (project *internal.Project, e error) {
+
+	// Where are we?
+	cwd, e := os.Getwd()
+
+	if e != nil {
+		return
+	}
+
+	// Init new project with the current working directory
+	project = &internal.Project{
+		Path:  cwd,
+		Temp:  utils.Temp(),
+		Repo:  c.String("url"),
+		Name:  c.String("name"),
+		User:  c.String("user"),
+		Auth:  os.Getenv("APKER_AUTH"),
+		Local: c.Bool("local"),
+		Ref:   c.String("ref"),
+
+		KnownHosts:   c.String("knownhosts"),
+		NoPinHostKey: c.Bool("no-pin-hostkey"),
+		Jump:         c.String("jump"),
+	}
+
+	var tmp []byte
+
+	// Load local deployments state
+	if project.State, e = internal.LoadState(); e != nil {
+		return
+	}
+
+	if project.Local && project.Ref != "" {
+
+		e = errors.New("--ref can't be used with --local, checkout the ref locally instead.")
+		return
+
+	} else if project.Local {
+
+		// Deploy the working tree, apker.yaml is read from it.
+		project.Repo = cwd
+
+		if tmp, e = ioutil.ReadFile(cwd + "/apker.yaml"); e != nil {
+			return
+		}
+
+	} else if project.Repo == "" {
+
+		// Get remote url
+		if tmp, e = utils.Run("git", []string{"config", "--get", "remote.origin.url"}); e != nil {
+
+			e = errors.New("Get remote repository url error: " + e.Error())
+			return
+		}
+
+		project.Repo = strings.TrimSpace(string(tmp))
+	}
+
+	// Other commands don't need it and work offline.
+	if deploy && !project.Local {
+
+		if e = resolveCommit(project); e != nil {
+			return
+		}
+	}
+
+	// Get content of apker.yaml
+	if !project.Local {
+
+		ref := project.Ref
+
+		if project.Commit != "" {
+			ref = project.Commit
+		}
+
+		if tmp, e = utils.GitFile(project.Repo, "apker.yaml", project.Auth, ref); e != nil {
+			return
+		}
+	}
+
+	// Save apker.yaml to temp file
+	if e = ioutil.WriteFile(project.Temp+"/apker.yaml", tmp, 0600); e != nil {
+		return
+	}
+
+	// Load project config from apker.yaml
+	if project.Config, e = internal.LoadConfig(project.Temp, c.StringSlice("parameter")); e != nil {
+		return
+	}
+
+	// Validate config.
+	if e = project.Config.Validate(); e != nil {
+		return
+	}
+
+	// Jump hosts flag overrides apker.yaml.
+	if project.Jump == "" {
+		project.Jump = project.Config.SSH.Jump
+	}
+
+	// Project name fallback
+	if project.Name == "" && project.Config.Name != "" {
+		project.Name = "apker-" + project.Config.Name
+	}
+
+	return
+}
+
+// Resolve project ref (default: the repository default branch) commit.
+func resolveCommit(project *internal.Project) (e error) {
+
+	if project.Ref == "" {
+
+		if project.Ref, e = utils.DefaultBranch(project.Repo, project.Auth); e != nil {
+			return
+		}
+	}
+
+	project.Commit, e = utils.RemoteCommit(project.Repo, project.Auth, project.Ref)
+	return
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Get secret from a file flag or an env var, ok is false when none of them is set.
+func Secret(c *cli.Context, fileFlag string, env string) (value string, ok bool, e error) {
+
+	var data []byte
+
+	if file := c.String(fileFlag); file != "" {
+
+		if data, e = ioutil.ReadFile(file); e != nil {
+			return
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+
+	value, ok = os.LookupEnv(env)
+	return
+}
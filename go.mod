@@ -10,6 +10,7 @@ require (
 	github.com/melbahja/goph v0.3.1
 	github.com/melbahja/promptui v0.7.1-0.20200330222651-3563d9548264
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pkg/sftp v1.11.0
 	github.com/rs/xid v1.2.1
 	github.com/urfave/cli/v2 v2.2.0
 	golang.org/x/crypto v0.0.0-20200423211502-4bdfaf469ed5
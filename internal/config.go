@@ -26,11 +26,18 @@ type Config struct {
 		Credentials map[string]string `yaml:"credentials"`
 	} `yaml:"provider"`
 	Deploy struct {
-		Env   map[string]string `yaml:env`
-		Setup []string          `yaml:"setup"`
-		Steps []string          `yaml:"steps"`
+		Env      map[string]string `yaml:"env"`
+		Setup    []string          `yaml:"setup"`
+		Steps    []Step            `yaml:"steps"`
+		Count    int               `yaml:"count"`
+		Hosts    []string          `yaml:"hosts"`
+		Strategy Strategy          `yaml:"strategy"`
 	} `yaml:"deploy"`
+	SSH struct {
+		Jump string `yaml:"jump"`
+	} `yaml:"ssh"`
 	Actions map[string]string `yaml:"actions"`
+	Params  map[string]string `yaml:"-"`
 	Events  struct {
 		Failure string `yaml:"failure"`
 		Success string `yaml:"success"`
@@ -43,6 +50,14 @@ func (c Config) Validate() error {
 		return fmt.Errorf("Image name or url is required.")
 	}
 
+	if c.Deploy.Count < 0 {
+		return fmt.Errorf("Invalid deploy count: %d", c.Deploy.Count)
+	}
+
+	if e := c.Deploy.Strategy.Validate(); e != nil {
+		return e
+	}
+
 	return checkSteps(c.Deploy.Steps)
 }
 
@@ -66,8 +81,28 @@ func parseParams(params []string) map[string]string {
 
 func parseTpl(file string, name string, params []string) (c string, e error) {
 
-	paramsMap := parseParams(params)
-	tpl, e := template.New(name).Funcs(template.FuncMap{
+	tpl, e := template.New(name).Funcs(tplFuncs(parseParams(params))).ParseFiles(file)
+
+	if e != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+
+	e = tpl.Execute(&buf, "")
+
+	if e != nil {
+		return
+	}
+
+	c = buf.String()
+	return
+}
+
+// Template functions, shared by apker.yaml and template steps.
+func tplFuncs(paramsMap map[string]string) template.FuncMap {
+
+	return template.FuncMap{
 		"Env": func(key string) string {
 			return utils.Env(key, false)
 		},
@@ -97,46 +132,21 @@ func parseTpl(file string, name string, params []string) (c string, e error) {
 
 			return string(val)
 		},
-	}).ParseFiles(file)
-
-	if e != nil {
-		return
 	}
-
-	var buf bytes.Buffer
-
-	e = tpl.Execute(&buf, "")
-
-	if e != nil {
-		return
-	}
-
-	c = buf.String()
-	return
 }
 
-// TODO: check steps len
-func checkSteps(steps []string) error {
-
-	var step []string
-
-loop:
-
-	for k, v := range steps {
-
-		step = strings.Split(v, " ")
+func checkSteps(steps []Step) error {
 
-		switch step[0] {
+	for k, step := range steps {
 
-		case "run", "copy", "dir":
-			continue loop
+		if e := step.Validate(); e != nil && step.String() != "" {
+			return fmt.Errorf("Invalid deploy step #%d (%s): %s", k+1, step.String(), e.Error())
+		} else if e != nil {
+			return fmt.Errorf("Invalid deploy step #%d: %s", k+1, e.Error())
+		}
 
-		case "reboot":
-			if k != len(steps)-1 {
-				return fmt.Errorf("reboot command should be the last step.")
-			}
-		default:
-			return fmt.Errorf("Unknown deploy step: %s", v)
+		if step.Type == StepReboot && k != len(steps)-1 {
+			return fmt.Errorf("Invalid deploy step #%d: reboot command should be the last step.", k+1)
 		}
 	}
 
@@ -151,6 +161,10 @@ func LoadConfig(projectDirectory string, params []string) (c *Config, e error) {
 		return
 	}
 
-	e = yaml.Unmarshal([]byte(data), &c)
+	if e = yaml.Unmarshal([]byte(data), &c); e != nil || c == nil {
+		return
+	}
+
+	c.Params = parseParams(params)
 	return
 }
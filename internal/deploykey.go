@@ -0,0 +1,95 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unleashable/apker/internal/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// Throwaway ssh key generated for a deploy and injected by the provider,
+// so machines and their snapshots don't trust personal keys.
+type DeployKey struct {
+	Private []byte
+	Public  ssh.PublicKey
+
+	// Keep the key in the machine and store it in state,
+	// otherwise it's removed from the machine after deploy.
+	Keep bool
+}
+
+func NewDeployKey(name string, keep bool) (key *DeployKey, e error) {
+
+	key = &DeployKey{Keep: keep}
+	key.Private, key.Public, e = utils.GenerateKey("apker-deploy@" + name)
+	return
+}
+
+// Get stored deploy keys directory.
+func KeysDir() string {
+
+	return filepath.Join(StateDir(), "keys")
+}
+
+// Save private key to keys directory and get its path, each key gets its
+// own file, machines of the same project don't share keys.
+func (k *DeployKey) Save(name string) (file string, e error) {
+
+	var f *os.File
+
+	if e = os.MkdirAll(KeysDir(), 0700); e != nil {
+		return
+	}
+
+	// Temp files are created with 0600 permissions.
+	if f, e = ioutil.TempFile(KeysDir(), name+"-*.key"); e != nil {
+		return
+	}
+
+	if _, e = f.Write(k.Private); e != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+
+	if e = f.Close(); e != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	return f.Name(), nil
+}
+
+// Get remote command that removes the key from root authorized keys.
+func (k *DeployKey) RemoveCommand() string {
+
+	return "f=~/.ssh/authorized_keys && grep -vF " + utils.ShellQuote(base64.StdEncoding.EncodeToString(k.Public.Marshal())) +
+		` "$f" > "$f.apker"; cat "$f.apker" > "$f" && rm -f "$f.apker"`
+}
+
+// Use deploy key for the project ssh auth and provider key registration.
+func (project *Project) SetDeployKey(key *DeployKey) error {
+
+	signer, e := ssh.ParsePrivateKey(key.Private)
+
+	if e != nil {
+		return e
+	}
+
+	project.DeployKey = key
+	project.SSHAuth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	project.PublicKey = PublicSSHKey{
+		Fingerprint: ssh.FingerprintLegacyMD5(key.Public),
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.Public))),
+	}
+	project.PrivateKey = PrivateSSHKey{}
+
+	return nil
+}
@@ -4,31 +4,69 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/melbahja/goph"
 	"github.com/unleashable/apker/internal/utils"
+	"golang.org/x/crypto/ssh"
 )
 
 type OutputHandler func(description string, log []byte) error
 
 type ProgressHandler func(log string) error
 
+type StepHandler func(step StepLog) error
+
 type ExecStep struct {
 	Done    string
 	Label   string
 	Command string
+
+	// Optional, shown and logged instead of the command (e.g: without credentials).
+	Display string
+
+	// Optional, runs instead of the remote command.
+	Func func() ([]byte, error)
 }
 
-type Deployment struct {
-	SSH             *goph.Client
-	Project         *Project
+// Get the step command to show.
+func (s ExecStep) display() string {
+
+	if s.Display != "" {
+		return s.Display
+	}
+
+	return s.Command
+}
+
+type Handlers struct {
 	StdoutHandler   OutputHandler
 	StderrHandler   OutputHandler
 	ProgressHandler ProgressHandler
+
+	// Optional, called with each remote output line as it arrives,
+	// the description is the stream name: stdout or stderr.
+	LineHandler OutputHandler
+
+	// Optional, called when a step is finished.
+	StepHandler StepHandler
+}
+
+type Deployment struct {
+	Handlers
+	SSH     *goph.Client
+	Log     *RunLog
+	Project *Project
 }
 
 func (d *Deployment) Run() (e error) {
@@ -47,15 +85,48 @@ func (d *Deployment) Run() (e error) {
 		})
 	}
 
+	if d.Project.Local {
+
+		steps = append(steps, ExecStep{
+			Done:    "Setup: tar and rsync installed.",
+			Label:   "Verifying requirements...",
+			Command: "which tar rsync && rsync --version",
+		}, ExecStep{
+			Done:    "Setup: project uploaded on: /tmp/apker",
+			Label:   fmt.Sprintf("Uploading project working tree: %s", d.Project.Path),
+			Command: "upload working tree",
+			Func:    d.uploadWorkTree,
+		})
+
+	} else {
+
+		clone := fmt.Sprintf("rm -rf /tmp/apker && git clone %s /tmp/apker/", utils.ShellQuote(utils.UrlAuth(d.Project.Repo, d.Project.Auth)))
+
+		// Pinned commit, all machines deploy the same code.
+		if d.Project.Commit != "" {
+			clone += " && git -C /tmp/apker checkout -q " + utils.ShellQuote(d.Project.Commit)
+		} else if d.Project.Ref != "" {
+			clone += " && git -C /tmp/apker checkout -q " + utils.ShellQuote(d.Project.Ref)
+		}
+
+		steps = append(steps, ExecStep{
+			Done:    "Setup: git and rsync installed.",
+			Label:   "Verifying requirements...",
+			Command: "which git rsync && git --version && rsync --version",
+		}, ExecStep{
+			Done:    "Setup: project cloned on: /tmp/apker",
+			Label:   fmt.Sprintf("Cloning project repository: %s", utils.RedactUrl(d.Project.Repo)),
+			Command: clone,
+			Display: utils.RedactUrl(clone),
+		}, ExecStep{
+			Done:    "Setup: project commit resolved.",
+			Label:   "Resolving project commit...",
+			Command: "git -C /tmp/apker rev-parse HEAD",
+			Func:    d.resolveCommit,
+		})
+	}
+
 	steps = append(steps, ExecStep{
-		Done:    "Setup: git and rsync installed.",
-		Label:   "Verifying requirements...",
-		Command: "which git rsync && git --version && rsync --version",
-	}, ExecStep{
-		Done:    "Setup: project cloned on: /tmp/apker",
-		Label:   fmt.Sprintf("Cloning project repository: %s", d.Project.Repo),
-		Command: fmt.Sprintf("rm -rf /tmp/apker && git clone %s /tmp/apker/", utils.UrlAuth(d.Project.Repo, d.Project.Auth)),
-	}, ExecStep{
 		Done:    "Setup: apker directory created.",
 		Label:   "Creating apker directory...",
 		Command: "mkdir -p /usr/share/apker/bin/",
@@ -67,15 +138,50 @@ func (d *Deployment) Run() (e error) {
 
 	for _, step := range d.Project.Config.Deploy.Steps {
 
-		if command, e = stepToCommand(step); e != nil {
-			return
+		exec := ExecStep{
+			Done:  fmt.Sprintf("Step: %s", step.String()),
+			Label: fmt.Sprintf("Running: %s", step.String()),
 		}
 
-		steps = append(steps, ExecStep{
-			Done:    fmt.Sprintf("Step: %s", step),
-			Label:   fmt.Sprintf("Running: %s", step),
-			Command: command,
-		})
+		switch step := step; step.Type {
+		case StepUpload:
+
+			exec.Command = step.String()
+			exec.Func = func() ([]byte, error) {
+				return d.upload(step.Upload)
+			}
+
+		case StepTemplate:
+
+			exec.Command = step.String()
+			exec.Func = func() ([]byte, error) {
+				return d.template(step.Template)
+			}
+
+		default:
+
+			if exec.Command, e = stepToCommand(step); e != nil {
+				return
+			}
+		}
+
+		steps = append(steps, exec)
+	}
+
+	// Remove deploy key before reboot, the last step.
+	if key := d.Project.DeployKey; key != nil && !key.Keep {
+
+		i := len(steps)
+
+		if n := len(d.Project.Config.Deploy.Steps); n > 0 && d.Project.Config.Deploy.Steps[n-1].Type == StepReboot {
+			i--
+		}
+
+		steps = append(steps[:i], append([]ExecStep{{
+			Done:    "Deploy key removed.",
+			Label:   "Removing deploy key...",
+			Command: key.RemoveCommand(),
+		}}, steps[i:]...)...)
 	}
 
 	return d.exec(steps)
@@ -84,13 +190,19 @@ func (d *Deployment) Run() (e error) {
 func (d Deployment) exec(steps []ExecStep) (e error) {
 
 	var (
-		env    string = envToString(d.Project.Config.Deploy.Env)
-		result []byte
+		env     string
+		result  runResult
+		started time.Time
 	)
 
+	if env, e = envToString(d.Project.Config.Deploy.Env); e != nil {
+		d.StderrHandler(fmt.Sprintf("Deploy env error: %s", e.Error()), nil)
+		return
+	}
+
 	// Setup actions.
 	if e = d.setupActions(); e != nil {
-		d.StderrHandler(fmt.Sprintf("Setup actions error: %s", e.Error()), result)
+		d.StderrHandler(fmt.Sprintf("Setup actions error: %s", e.Error()), result.Output)
 		return
 	}
 
@@ -98,23 +210,166 @@ func (d Deployment) exec(steps []ExecStep) (e error) {
 
 		d.ProgressHandler(step.Label)
 
-		if result, e = d.SSH.Run(fmt.Sprintf("env %s bash -c '%s'", env, step.Command)); e != nil {
+		started = time.Now()
+
+		if step.Func != nil {
+			result.Output, e = step.Func()
+			result.Stdout, result.Stderr = result.Output, nil
+		} else {
+			result, e = d.run(fmt.Sprintf("env %s %s", env, utils.BashCommand(step.Command)))
+		}
+
+		d.logStep(step, started, result, e)
+
+		if e != nil {
 
-			d.StderrHandler(fmt.Sprintf("Label: %s\nCommand: %s", step.Label, step.Command), result)
+			d.StderrHandler(fmt.Sprintf("Label: %s\nCommand: %s", step.Label, step.display()), []byte(utils.RedactUrl(string(result.Output))))
 			return
 		}
 
-		d.StdoutHandler(step.Done, result)
+		d.StdoutHandler(step.Done, result.Output)
+	}
+
+	return
+}
+
+// Save step to deployment run log.
+func (d Deployment) logStep(step ExecStep, started time.Time, result runResult, e error) {
+
+	// Git errors can include the authenticated repository url.
+	log := StepLog{
+		Label:    step.Label,
+		Command:  step.display(),
+		ExitCode: exitCode(e),
+		Started:  started,
+		Duration: time.Since(started),
+		Stdout:   utils.RedactUrl(string(result.Stdout)),
+		Stderr:   utils.RedactUrl(string(result.Stderr)),
+	}
+
+	if e != nil {
+		log.Error = utils.RedactUrl(e.Error())
+	}
+
+	d.Log.AddStep(log)
+
+	if d.StepHandler != nil {
+		d.StepHandler(log)
+	}
+}
+
+// Remote command output.
+type runResult struct {
+	Output []byte
+	Stdout []byte
+	Stderr []byte
+}
+
+// Run remote command and stream its output lines.
+func (d Deployment) run(cmd string) (res runResult, e error) {
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		buf    bytes.Buffer
+		outBuf bytes.Buffer
+		errBuf bytes.Buffer
+		sess   *ssh.Session
+		stdout io.Reader
+		stderr io.Reader
+	)
+
+	if sess, e = d.SSH.NewSession(); e != nil {
+		return
+	}
+
+	defer sess.Close()
+
+	if stdout, e = sess.StdoutPipe(); e != nil {
+		return
+	}
+
+	if stderr, e = sess.StderrPipe(); e != nil {
+		return
+	}
+
+	stream := func(name string, r io.Reader, w *bytes.Buffer) {
+
+		defer wg.Done()
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+
+			mu.Lock()
+
+			for _, b := range []*bytes.Buffer{&buf, w} {
+				b.Write(scanner.Bytes())
+				b.WriteByte('\n')
+			}
+
+			if d.LineHandler != nil {
+				d.LineHandler(name, []byte(utils.RedactUrl(scanner.Text())))
+			}
+
+			mu.Unlock()
+		}
+
+		// Too long line, keep draining so the remote command doesn't block.
+		if err := scanner.Err(); err != nil {
+
+			mu.Lock()
+
+			for _, b := range []*bytes.Buffer{&buf, w} {
+				fmt.Fprintf(b, "apker: %s output skipped: %s\n", name, err.Error())
+			}
+
+			mu.Unlock()
+
+			io.Copy(ioutil.Discard, r)
+		}
+	}
+
+	if e = sess.Start(cmd); e != nil {
+		return
 	}
 
+	wg.Add(2)
+	go stream("stdout", stdout, &outBuf)
+	go stream("stderr", stderr, &errBuf)
+	wg.Wait()
+
+	e = sess.Wait()
+	res = runResult{
+		Output: buf.Bytes(),
+		Stdout: outBuf.Bytes(),
+		Stderr: errBuf.Bytes(),
+	}
 	return
 }
 
+// Get remote command exit code from its error.
+func exitCode(e error) int {
+
+	var exitErr *ssh.ExitError
+
+	switch {
+	case e == nil:
+		return 0
+	case errors.As(e, &exitErr):
+		return exitErr.ExitStatus()
+	}
+
+	return -1
+}
+
 func (d Deployment) setupActions() error {
 
 	var err error
 
-	file, err := os.OpenFile(d.Project.Temp+"/actions.sh", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	// Each deployment has its own file, deployments may run concurrently.
+	file, err := ioutil.TempFile(d.Project.Temp, "actions-*.sh")
 
 	if err != nil {
 		return err
@@ -126,55 +381,147 @@ func (d Deployment) setupActions() error {
 
 	for name, command := range d.Project.Config.Actions {
 
-		_, err = file.WriteString(fmt.Sprintf(`cat > /usr/share/apker/bin/%s << EOL
+		// Quoted heredoc delimiter, the action is written as is.
+		_, err = file.WriteString(fmt.Sprintf(`cat > %s << 'APKER_EOL'
 #!/usr/bin/sh
 %s
-EOL
-`, name, command))
+APKER_EOL
+`, utils.ShellQuote("/usr/share/apker/bin/"+name), command))
 
 		if err != nil {
 			return err
 		}
 	}
 
-	return d.SSH.Upload(d.Project.Temp+"/actions.sh", "/tmp/apker_actions.sh")
+	return d.SSH.Upload(file.Name(), "/tmp/apker_actions.sh")
+}
+
+// Get the checked out commit sha and record it.
+func (d *Deployment) resolveCommit() (out []byte, e error) {
+
+	if out, e = d.SSH.Run("git -C /tmp/apker rev-parse HEAD"); e != nil {
+		return
+	}
+
+	d.Project.Commit = strings.TrimSpace(string(out))
+	d.Log.Commit = d.Project.Commit
+	return
 }
 
-func envToString(vars map[string]string) string {
+// Package the local working tree and extract it to /tmp/apker.
+func (d Deployment) uploadWorkTree() (out []byte, e error) {
+
+	var (
+		files   []string
+		archive *os.File
+	)
 
-	env := []string{}
+	if files, e = utils.WorkTreeFiles(d.Project.Path); e != nil {
+		return
+	}
+
+	// Replicas share the project temp directory.
+	if archive, e = ioutil.TempFile(d.Project.Temp, "worktree-*.tar.gz"); e != nil {
+		return
+	}
+
+	archive.Close()
+
+	if e = utils.Tar(d.Project.Path, files, archive.Name()); e != nil {
+		return
+	}
+
+	if e = d.SSH.Upload(archive.Name(), "/tmp/apker.tar.gz"); e != nil {
+		return
+	}
+
+	return d.SSH.Run("rm -rf /tmp/apker && mkdir -p /tmp/apker && tar -xzf /tmp/apker.tar.gz -C /tmp/apker && rm -f /tmp/apker.tar.gz")
+}
+
+func envToString(vars map[string]string) (_ string, e error) {
+
+	var (
+		v   string
+		env []string
+	)
 
 	for i := range vars {
-		env = append(env, fmt.Sprintf("%s=%s", i, strconv.Quote(vars[i])))
+
+		if v, e = utils.ShellEnv(i, vars[i]); e != nil {
+			return
+		}
+
+		env = append(env, v)
 	}
 
-	return strings.Join(env, " ")
+	return strings.Join(env, " "), nil
 }
 
-func stepToCommand(step string) (c string, e error) {
+func stepToCommand(step Step) (c string, e error) {
+
+	switch step.Type {
+	case StepRun:
 
-	parts := strings.Split(step, " ")
+		cwd := "/tmp/apker"
 
-	switch parts[0] {
-	case "run":
-		c = fmt.Sprintf("cd /tmp/apker && %s", strings.Join(parts[1:], " "))
+		if step.Cwd != "" {
+			cwd = step.Cwd
+		}
+
+		c = step.Run
+
+		var v string
+
+		for _, name := range step.envNames() {
+
+			if v, e = utils.ShellEnv(name, step.Env[name]); e != nil {
+				return
+			}
+
+			c = fmt.Sprintf("export %s && %s", v, c)
+		}
+
+		if step.User != "" {
+			c = fmt.Sprintf("sudo -E -u %s -- %s", utils.ShellQuote(step.User), utils.BashCommand(c))
+		}
+
+		c = fmt.Sprintf("cd %s && %s", utils.ShellQuote(cwd), c)
 		break
 
-	case "copy":
-		c = fmt.Sprintf("cd /tmp/apker && rsync -av --quiet %s %s", strconv.Quote(parts[1]), strconv.Quote(parts[2]))
+	case StepCopy:
+
+		args := []string{"-av", "--quiet"}
+
+		// Mode and owner apply only to the copied files.
+		if step.Copy.Mode != "" {
+			args = append(args, "--chmod="+rsyncChmod(step.Copy.Mode))
+		}
+
+		if step.Copy.Owner != "" {
+			args = append(args, "--chown="+step.Copy.Owner)
+		}
+
+		c = "cd /tmp/apker && rsync " + utils.ShellJoin(append(args, step.Copy.Src, step.Copy.Dst)...)
 		break
 
-	case "dir":
-		c = fmt.Sprintf("mkdir -p %s", strings.Join(parts[1:], " "))
+	case StepDir:
+		c = "mkdir -p " + utils.ShellJoin(step.Dirs...)
 		break
 
-	case "reboot":
+	case StepReboot:
 		c = "reboot &"
 		break
 
 	default:
-		e = fmt.Errorf("Unknown step: %s", step)
+		e = fmt.Errorf("Unknown step: %s", step.String())
 	}
 
 	return
 }
+
+// Get rsync chmod of an octal mode, directories also get the search bit where readable.
+func rsyncChmod(mode string) string {
+
+	m, _ := strconv.ParseUint(mode, 8, 32)
+	return fmt.Sprintf("D%04o,F%04o", m|(m&0444)>>2, m)
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/melbahja/goph"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Deployments to many machines share the known hosts file.
+var knownHostsMu sync.Mutex
+
+// Get default known hosts file path.
+func DefaultKnownHosts() string {
+
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// Connect to the project machine, the host key is verified with the pinned
+// key when the machine was created by apker, otherwise with the known hosts
+// file, new hosts are trusted on first use and added to it. The connection
+// goes through the project jump hosts when set.
+func (project *Project) Connect() (client *goph.Client, e error) {
+
+	client = &goph.Client{
+		Port: 22,
+		Addr: project.Addr,
+		User: project.User,
+		Auth: project.SSHAuth,
+	}
+
+	// Deploy key stored in state for this machine.
+	if m, ok := project.State.Project(project.StateName()).MachineByName(project.Name); ok && m.Key != "" && project.DeployKey == nil {
+
+		if signer, err := goph.GetSigner(m.Key, ""); err == nil {
+			client.Auth = append(goph.Auth{ssh.PublicKeys(signer)}, client.Auth...)
+		}
+	}
+
+	config := &ssh.ClientConfig{
+		User:            project.User,
+		Auth:            client.Auth,
+		Timeout:         20 * time.Second,
+		HostKeyCallback: project.verifyHostKey,
+	}
+
+	// Only ask for the pinned key type.
+	if project.HostKey != nil {
+		config.HostKeyAlgorithms = []string{project.HostKey.Type()}
+	}
+
+	client.Conn, e = project.Jumper(client.Auth).Client(net.JoinHostPort(project.Addr, "22"), config)
+	return
+}
+
+// Get jump hosts dialer for the project machine, jump hosts keys are
+// verified with the known hosts file.
+func (project *Project) Jumper(auth goph.Auth) Jump {
+
+	// Deploy keys are only valid on the machine.
+	if project.JumpAuth != nil {
+		auth = project.JumpAuth
+	}
+
+	return Jump{
+		Hosts:    project.Jump,
+		Auth:     auth,
+		Callback: project.verifyKnownHost,
+	}
+}
+
+// Check if machine port is open, through the jump hosts when set.
+func (project *Project) IsPortOpen(port int, timeout time.Duration) bool {
+
+	jump := project.Jumper(project.SSHAuth)
+	jump.Timeout = timeout
+	conn, e := jump.Dial("tcp", net.JoinHostPort(project.Addr, fmt.Sprint(port)))
+
+	if e != nil {
+		return false
+	}
+
+	conn.Close()
+	return true
+}
+
+func (project *Project) knownHosts() string {
+
+	if project.KnownHosts != "" {
+		return project.KnownHosts
+	}
+
+	return DefaultKnownHosts()
+}
+
+func (project *Project) verifyHostKey(host string, remote net.Addr, key ssh.PublicKey) error {
+
+	if project.HostKey == nil {
+		return project.verifyKnownHost(host, remote, key)
+	}
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	file := project.knownHosts()
+
+	if e := touch(file); e != nil {
+		return e
+	}
+
+	if !bytes.Equal(key.Marshal(), project.HostKey.Marshal()) {
+		return fmt.Errorf(
+			"Host key mismatch for %s: got %s, want pinned %s. The pinned key is installed with cloud-init, for images without it use --no-pin-hostkey.",
+			host, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(project.HostKey),
+		)
+	}
+
+	// Machine address may be reused, old keys are not valid anymore.
+	return replaceKnownHost(file, host, key)
+}
+
+// Verify host key with the known hosts file, unknown hosts are added to it.
+func (project *Project) verifyKnownHost(host string, remote net.Addr, key ssh.PublicKey) error {
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	file := project.knownHosts()
+
+	if e := touch(file); e != nil {
+		return e
+	}
+
+	found, e := goph.CheckKnownHost(host, remote, key, file)
+
+	if found || e != nil {
+		return e
+	}
+
+	return goph.AddKnownHost(host, remote, key, file)
+}
+
+// Replace host keys in known hosts file, hashed entries are kept.
+func replaceKnownHost(file string, host string, key ssh.PublicKey) (e error) {
+
+	var (
+		buf  bytes.Buffer
+		data []byte
+		name = knownhosts.Normalize(host)
+	)
+
+	if data, e = ioutil.ReadFile(file); e != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+
+		if fields := strings.Fields(scanner.Text()); len(fields) > 1 && hasHost(fields[0], name) {
+			continue
+		}
+
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+	}
+
+	buf.WriteString(knownhosts.Line([]string{name}, key) + "\n")
+
+	return ioutil.WriteFile(file, buf.Bytes(), 0600)
+}
+
+func hasHost(hosts string, host string) bool {
+
+	for _, h := range strings.Split(hosts, ",") {
+
+		if h == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Create file and its directory if not exists.
+func touch(file string) error {
+
+	if e := os.MkdirAll(filepath.Dir(file), 0700); e != nil {
+		return e
+	}
+
+	f, e := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0600)
+
+	if e != nil {
+		return e
+	}
+
+	return f.Close()
+}
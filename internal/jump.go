@@ -0,0 +1,213 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/melbahja/goph"
+	"golang.org/x/crypto/ssh"
+)
+
+// Jump hosts dialer, hosts are comma separated [user@]host[:port] like ssh -J,
+// connections go through each host in order.
+type Jump struct {
+	Hosts string
+
+	// Jump hosts default user, like ssh -J it's the local user.
+	User string
+
+	Auth     goph.Auth
+	Callback ssh.HostKeyCallback
+
+	// Timeout of each hop dial and handshake.
+	Timeout time.Duration
+}
+
+// Jump connection, closing it closes the jump hosts clients.
+type jumpConn struct {
+	net.Conn
+	clients []*ssh.Client
+}
+
+func (c *jumpConn) Close() error {
+
+	e := c.Conn.Close()
+	closeClients(c.clients)
+	return e
+}
+
+// Dial addr through the jump hosts, or directly when there are none.
+func (j Jump) Dial(network string, addr string) (_ net.Conn, e error) {
+
+	var (
+		conn    net.Conn
+		client  *ssh.Client
+		clients []*ssh.Client
+	)
+
+	if j.Timeout == 0 {
+		j.Timeout = 20 * time.Second
+	}
+
+	if j.User == "" {
+		j.User = localUser()
+	}
+
+	if strings.TrimSpace(j.Hosts) == "" {
+		return net.DialTimeout(network, addr, j.Timeout)
+	}
+
+	defer func() {
+		if e != nil {
+			closeClients(clients)
+		}
+	}()
+
+	for _, hop := range strings.Split(j.Hosts, ",") {
+
+		user, hopAddr := j.parseHop(strings.TrimSpace(hop))
+
+		// First hop is dialed directly, next ones through the previous hop.
+		if len(clients) == 0 {
+			conn, e = net.DialTimeout("tcp", hopAddr, j.Timeout)
+		} else {
+			conn, e = j.dialThrough(clients[len(clients)-1], "tcp", hopAddr)
+		}
+
+		if e != nil {
+			return
+		}
+
+		client, e = newClient(conn, hopAddr, &ssh.ClientConfig{
+			User:            user,
+			Auth:            j.Auth,
+			Timeout:         j.Timeout,
+			HostKeyCallback: j.Callback,
+		}, j.Timeout)
+
+		if e != nil {
+			return nil, fmt.Errorf("Jump host %s: %s", hopAddr, e.Error())
+		}
+
+		clients = append(clients, client)
+	}
+
+	if conn, e = j.dialThrough(client, network, addr); e != nil {
+		return
+	}
+
+	return &jumpConn{Conn: conn, clients: clients}, nil
+}
+
+// Connect ssh client to addr through the jump hosts.
+func (j Jump) Client(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+
+	conn, e := j.Dial("tcp", addr)
+
+	if e != nil {
+		return nil, e
+	}
+
+	timeout := config.Timeout
+
+	if timeout == 0 {
+		timeout = 20 * time.Second
+	}
+
+	return newClient(conn, addr, config, timeout)
+}
+
+// Dial addr through a jump host client, the client is closed on timeout
+// since ssh channels have no dial deadline.
+func (j Jump) dialThrough(client *ssh.Client, network string, addr string) (conn net.Conn, e error) {
+
+	e = withTimeout(j.Timeout, client.Close, func() (err error) {
+		conn, err = client.Dial(network, addr)
+		return
+	})
+
+	if e != nil {
+		return nil, fmt.Errorf("Dial %s through jump host: %s", addr, e.Error())
+	}
+
+	return
+}
+
+// Get hop user and address, default user is the jump user and port 22.
+func (j Jump) parseHop(hop string) (user string, addr string) {
+
+	user, addr = j.User, hop
+
+	if i := strings.LastIndex(hop, "@"); i != -1 {
+		user, addr = hop[:i], hop[i+1:]
+	}
+
+	if _, _, e := net.SplitHostPort(addr); e != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	return
+}
+
+// Get ssh client from conn, the conn is closed when the handshake
+// takes longer than timeout.
+func newClient(conn net.Conn, addr string, config *ssh.ClientConfig, timeout time.Duration) (_ *ssh.Client, e error) {
+
+	var (
+		c     ssh.Conn
+		chans <-chan ssh.NewChannel
+		reqs  <-chan *ssh.Request
+	)
+
+	e = withTimeout(timeout, conn.Close, func() (err error) {
+		c, chans, reqs, err = ssh.NewClientConn(conn, addr, config)
+		return
+	})
+
+	if e != nil {
+		conn.Close()
+		return
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Run fn, cancel is called to unblock it when it takes longer than timeout.
+func withTimeout(timeout time.Duration, cancel func() error, fn func() error) error {
+
+	timer := time.AfterFunc(timeout, func() {
+		cancel()
+	})
+
+	e := fn()
+
+	if !timer.Stop() {
+		return fmt.Errorf("Timeout after %s", timeout)
+	}
+
+	return e
+}
+
+// Get current local user name.
+func localUser() string {
+
+	if u, e := user.Current(); e == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+func closeClients(clients []*ssh.Client) {
+
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
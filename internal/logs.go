@@ -0,0 +1,173 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/unleashable/apker/internal/utils"
+)
+
+type StepLog struct {
+	Label    string        `json:"label"`
+	Command  string        `json:"command"`
+	ExitCode int           `json:"exit_code"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Deployment run log, saved to its own directory in RunsDir.
+type RunLog struct {
+	mu        sync.Mutex
+	ID        string    `json:"id"`
+	Project   string    `json:"project"`
+	Machine   string    `json:"machine"`
+	MachineID int       `json:"machine_id"`
+	Addr      string    `json:"addr"`
+	Repo      string    `json:"repo"`
+	Ref       string    `json:"ref,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+	Steps     []StepLog `json:"steps"`
+}
+
+// Get runs logs directory, override it with APKER_RUNS env var.
+func RunsDir() string {
+
+	if dir := os.Getenv("APKER_RUNS"); dir != "" {
+		return dir
+	}
+
+	return filepath.Join(StateDir(), "runs")
+}
+
+func NewRunLog(project *Project) *RunLog {
+
+	return &RunLog{
+		ID:        xid.New().String(),
+		Project:   project.StateName(),
+		Machine:   project.Name,
+		MachineID: project.MachineID,
+		Addr:      project.Addr,
+		Repo:      utils.RedactUrl(project.Repo),
+		Ref:       project.Ref,
+		Started:   time.Now(),
+		Steps:     []StepLog{},
+	}
+}
+
+// Add step log and save the run, it's a no-op on nil log.
+func (r *RunLog) AddStep(step StepLog) error {
+
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	r.Steps = append(r.Steps, step)
+	r.mu.Unlock()
+
+	return r.Save()
+}
+
+// Mark run as finished and save it.
+func (r *RunLog) Finish(e error) error {
+
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+
+	r.Finished = time.Now()
+	r.Success = e == nil
+
+	if e != nil {
+		r.Error = e.Error()
+	}
+
+	r.mu.Unlock()
+
+	return r.Save()
+}
+
+func (r *RunLog) Save() (e error) {
+
+	var data []byte
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir := filepath.Join(RunsDir(), r.ID)
+
+	if e = os.MkdirAll(dir, 0700); e != nil {
+		return
+	}
+
+	if data, e = json.MarshalIndent(r, "", "  "); e != nil {
+		return
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "run.json"), data, 0600)
+}
+
+func LoadRunLog(id string) (r *RunLog, e error) {
+
+	var data []byte
+
+	if data, e = ioutil.ReadFile(filepath.Join(RunsDir(), id, "run.json")); e != nil {
+		return
+	}
+
+	r = &RunLog{}
+	e = json.Unmarshal(data, r)
+	return
+}
+
+// List saved runs logs, sorted by start time.
+func ListRunLogs() (runs []*RunLog, e error) {
+
+	var dirs []os.FileInfo
+
+	if dirs, e = ioutil.ReadDir(RunsDir()); os.IsNotExist(e) {
+		return nil, nil
+	} else if e != nil {
+		return
+	}
+
+	for _, dir := range dirs {
+
+		if !dir.IsDir() {
+			continue
+		}
+
+		r, err := LoadRunLog(dir.Name())
+
+		// Skip broken or not a run directories.
+		if err != nil {
+			continue
+		}
+
+		runs = append(runs, r)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Started.Before(runs[j].Started)
+	})
+
+	return
+}
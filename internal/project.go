@@ -4,13 +4,20 @@
 package internal
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/melbahja/goph"
 	"github.com/unleashable/apker/internal/utils"
+	"golang.org/x/crypto/ssh"
 )
 
 type PublicSSHKey struct {
 	Fingerprint string
 	Path        string
+
+	// Authorized key line, registered with providers.
+	Key string
 }
 
 type PrivateSSHKey struct {
@@ -20,35 +27,94 @@ type PrivateSSHKey struct {
 type Project struct {
 	*Config
 	Addr       string
+	MachineID  int
 	User       string
 	Repo       string
+	Ref        string
+	Commit     string
 	Auth       string
 	Name       string
+	Group      string
 	Path       string
 	Temp       string
+	Local      bool
 	SSHAuth    goph.Auth
+	KnownHosts string
+	Jump       string
+	JumpAuth   goph.Auth
 	PublicKey  PublicSSHKey
 	PrivateKey PrivateSSHKey
+	State      *State
+
+	// Provider machine record, nil when the provider didn't create it.
+	Machine *Machine
+
+	// Machine host key pinned at creation, see Connect.
+	HostKey ssh.PublicKey
+
+	// Don't pin host keys, for images without cloud-init.
+	NoPinHostKey bool
+
+	// Optional, throwaway key used instead of the user keys.
+	DeployKey *DeployKey
+}
+
+// Get project name in state, replicas are grouped by the main project name.
+func (project *Project) StateName() string {
+
+	if project.Group != "" {
+		return project.Group
+	}
+
+	return project.Name
+}
+
+// Get a copy of the project for a replica machine.
+func (project *Project) Replica(i int) *Project {
+
+	replica := *project
+	replica.Group = project.StateName()
+	replica.Name = fmt.Sprintf("%s-%d", replica.Group, i)
+	replica.Addr = ""
+	replica.MachineID = 0
+	replica.Machine = nil
+	replica.HostKey = nil
+
+	return &replica
 }
 
-func (project *Project) Deploy(allowEvents bool, outHandler OutputHandler, errHandler OutputHandler, itHandler ProgressHandler) error {
+func (project *Project) Deploy(allowEvents bool, handlers Handlers) error {
 
 	if project.User == "" {
 		project.User = "root"
 	}
 
-	client, e := goph.NewUnknown(project.User, project.Addr, project.SSHAuth)
+	runLog := NewRunLog(project)
+
+	record := DeployRecord{
+		RunID:     runLog.ID,
+		MachineID: project.MachineID,
+		Addr:      project.Addr,
+		Repo:      project.Repo,
+		Ref:       project.Ref,
+		Started:   runLog.Started,
+	}
+
+	client, e := project.Connect()
 
 	if e != nil {
+		runLog.Finish(e)
+		project.saveDeploy(record, false, e)
 		return e
 	}
 
+	defer client.Close()
+
 	deployment := &Deployment{
-		SSH:             client,
-		Project:         project,
-		StdoutHandler:   outHandler,
-		StderrHandler:   errHandler,
-		ProgressHandler: itHandler,
+		SSH:      client,
+		Log:      runLog,
+		Project:  project,
+		Handlers: handlers,
 	}
 
 	var out []byte
@@ -59,15 +125,65 @@ func (project *Project) Deploy(allowEvents bool, outHandler OutputHandler, errHa
 
 			// Run failure event
 			out, _ = utils.Run("sh", []string{"-c", project.Config.Events.Failure})
-			errHandler("Event: failure", out)
+			handlers.StderrHandler("Event: failure", out)
 		}
 
 	} else if allowEvents && project.Config.Events.Success != "" {
 
 		// Run success event
 		out, e = utils.Run("sh", []string{"-c", project.Config.Events.Success})
-		outHandler("Event: success", out)
+		handlers.StdoutHandler("Event: success", out)
+	}
+
+	// Deploy fails when its state can't be saved, a kept deploy key is
+	// the only machine credential.
+	if err := project.saveDeploy(record, true, e); e == nil {
+		e = err
 	}
 
+	runLog.Finish(e)
 	return e
 }
+
+// Record deploy result in project state, and the machine when it was reachable.
+func (project *Project) saveDeploy(record DeployRecord, connected bool, e error) (err error) {
+
+	record.Finished = time.Now()
+	record.Commit = project.Commit
+
+	machine := MachineState{
+		ID:       project.MachineID,
+		Name:     project.Name,
+		Addr:     project.Addr,
+		User:     project.User,
+		Provider: project.Config.Provider.Name,
+	}
+
+	if connected && project.DeployKey != nil && project.DeployKey.Keep {
+
+		if machine.Key, err = project.DeployKey.Save(project.Name); err != nil {
+			err = fmt.Errorf("Could not save deploy key: %s", err.Error())
+		}
+	}
+
+	if e == nil {
+		e = err
+	}
+
+	record.Success = e == nil
+
+	if e != nil {
+		record.Error = e.Error()
+	}
+
+	if serr := project.State.Update(project.StateName(), func(p *ProjectState) {
+		if connected {
+			p.SetMachine(machine)
+		}
+		p.AddDeploy(record)
+	}); err == nil {
+		err = serr
+	}
+
+	return
+}
@@ -3,9 +3,18 @@
 
 package internal
 
+import "errors"
+
+// Tag set on machines and images created by apker.
+const Tag = "apker"
+
+// Returned by providers for unsupported machine operations.
+var ErrNotSupported = errors.New("Operation not supported by this provider.")
+
 type Attributes map[string]interface{}
 
 type MachineStatus struct {
+	ImageID          int
 	IsImageReady     bool
 	IsImageInstalled bool
 	IsMachineReady   bool
@@ -14,15 +23,83 @@ type MachineStatus struct {
 }
 
 type Machine struct {
-	ID     int
-	Addr   string
-	Name   string
-	Region string
-	Status string
+	ID      int      `json:"id"`
+	Addr    string   `json:"addr"`
+	Name    string   `json:"name"`
+	Region  string   `json:"region"`
+	Status  string   `json:"status"`
+	Size    string   `json:"size"`
+	Image   string   `json:"image"`
+	Tags    []string `json:"tags"`
+	Created string   `json:"created"`
+}
+
+type Image struct {
+	ID      int
+	Name    string
+	Status  string
+	Created string
+}
+
+type Size struct {
+	Slug         string
+	Memory       int
+	Vcpus        int
+	Disk         int
+	PriceMonthly float64
+}
+
+type Region struct {
+	Slug      string
+	Name      string
+	Available bool
 }
 
 type Provider interface {
 
 	// Setup virtual machine on cloud provider
 	SetupMachine(chan MachineStatus, Attributes)
+
+	// Get machine by id.
+	Get(id int) (Machine, error)
+
+	// List machines by tag, an empty tag lists all machines.
+	List(tag string) ([]Machine, error)
+
+	// Destroy machine by id.
+	Destroy(id int) error
+
+	// Power off machine by id.
+	PowerOff(id int) error
+
+	// Power on machine by id.
+	PowerOn(id int) error
+
+	// Power cycle machine by id.
+	PowerCycle(id int) error
+
+	// Resize machine to a new size slug.
+	Resize(id int, size string) error
+
+	// Take a snapshot image of the machine.
+	Snapshot(id int, name string) error
+
+	// List custom images by tag.
+	Images(tag string) ([]Image, error)
+
+	// Destroy custom image by id.
+	DestroyImage(id int) error
+}
+
+// Cleaner is implemented by providers that create temporary
+// resources for a deploy, like registered ssh keys.
+type Cleaner interface {
+	Cleanup() error
+}
+
+// Catalog is implemented by providers that let users pick
+// machine sizes and regions.
+type Catalog interface {
+	Sizes() ([]Size, error)
+	Regions() ([]Region, error)
 }
@@ -0,0 +1,91 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package providers
+
+import (
+	"errors"
+
+	"github.com/unleashable/apker/internal"
+)
+
+var errNoAddr = errors.New("Please set machine ip address via 'addr' flag.")
+
+// Custom provider deploys to an already running machine.
+type Custom struct {
+	Project *internal.Project
+}
+
+func init() {
+
+	internal.RegisterProvider(internal.ProviderInfo{
+		Name: "custom",
+		New: func(p *internal.Project) (internal.Provider, error) {
+			return NewCustom(p)
+		},
+	})
+}
+
+func (c *Custom) SetupMachine(ch chan internal.MachineStatus, attrs internal.Attributes) {
+
+	if c.Project.Addr == "" {
+		ch <- internal.MachineStatus{Error: errNoAddr}
+		return
+	}
+
+	ch <- internal.MachineStatus{
+		Status:           "active",
+		IsImageReady:     true,
+		IsImageInstalled: true,
+		IsMachineReady:   true,
+	}
+}
+
+func (c *Custom) Get(id int) (internal.Machine, error) {
+	return internal.Machine{}, internal.ErrNotSupported
+}
+
+func (c *Custom) List(tag string) ([]internal.Machine, error) {
+	return nil, internal.ErrNotSupported
+}
+
+func (c *Custom) Destroy(id int) error {
+	return internal.ErrNotSupported
+}
+
+func (c *Custom) PowerOff(id int) error {
+	return internal.ErrNotSupported
+}
+
+func (c *Custom) PowerOn(id int) error {
+	return internal.ErrNotSupported
+}
+
+func (c *Custom) PowerCycle(id int) error {
+	return internal.ErrNotSupported
+}
+
+func (c *Custom) Resize(id int, size string) error {
+	return internal.ErrNotSupported
+}
+
+func (c *Custom) Snapshot(id int, name string) error {
+	return internal.ErrNotSupported
+}
+
+func (c *Custom) Images(tag string) ([]internal.Image, error) {
+	return nil, internal.ErrNotSupported
+}
+
+func (c *Custom) DestroyImage(id int) error {
+	return internal.ErrNotSupported
+}
+
+// Get custom provider, only deploy is supported and it requires the machine
+// address, other operations return internal.ErrNotSupported.
+func NewCustom(p *internal.Project) (*Custom, error) {
+
+	return &Custom{
+		Project: p,
+	}, nil
+}
@@ -6,21 +6,56 @@ package providers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/digitalocean/godo"
 	"github.com/unleashable/apker/internal"
 	"github.com/unleashable/apker/internal/utils"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/oauth2"
 )
 
+const imageDescription = "This image created by apker"
+
+// Guards ssh keys registration of replicas created concurrently.
+var keysMu sync.Mutex
+
 type Digitalocean struct {
 	DropletID int
 	ImageID   int
-	Oauth     *http.Client
-	DoClient  *godo.Client
-	Project   *internal.Project
+
+	// Ssh key registered by apker, see Cleanup.
+	KeyID int
+
+	Oauth    *http.Client
+	DoClient *godo.Client
+	Project  *internal.Project
+}
+
+func init() {
+
+	internal.RegisterProvider(internal.ProviderInfo{
+		Name: "digitalocean",
+		New: func(p *internal.Project) (internal.Provider, error) {
+			return NewDigitalocean(p)
+		},
+		Capabilities: internal.Capabilities{
+			Provision:    true,
+			CustomImages: true,
+		},
+		Credentials: []internal.Credential{
+			{
+				Name:     "API_KEY",
+				Env:      "APKER_KEY",
+				Usage:    "your_do_api_key",
+				Required: true,
+			},
+		},
+	})
 }
 
 type TokenSource struct {
@@ -65,7 +100,8 @@ func (do *Digitalocean) SetupMachine(ch chan internal.MachineStatus, attrs inter
 			Name:         do.Project.Name,
 			Region:       do.Project.Config.Image.Region,
 			Distribution: "Unknown",
-			Description:  "This image created by apker",
+			Description:  imageDescription,
+			Tags:         []string{internal.Tag},
 		})
 
 		if e != nil {
@@ -78,6 +114,20 @@ func (do *Digitalocean) SetupMachine(ch chan internal.MachineStatus, attrs inter
 
 		do.ImageID = image.ID
 
+		if e = do.saveImage(image.Status); e != nil {
+
+			ch <- internal.MachineStatus{
+				ImageID: do.ImageID,
+				Error:   e,
+			}
+			return
+		}
+
+		ch <- internal.MachineStatus{
+			ImageID: do.ImageID,
+			Status:  image.Status,
+		}
+
 		// Wait for image be ready in DO
 		for {
 
@@ -86,15 +136,35 @@ func (do *Digitalocean) SetupMachine(ch chan internal.MachineStatus, attrs inter
 
 			image, _, e = do.DoClient.Images.GetByID(context.TODO(), do.ImageID)
 
+			if e != nil {
+
+				ch <- internal.MachineStatus{
+					ImageID: do.ImageID,
+					Error:   e,
+				}
+				return
+			}
+
+			// Record the image before reporting it ready.
+			if image.Status == "available" {
+
+				if e = do.saveImage(image.Status); e != nil {
+
+					ch <- internal.MachineStatus{
+						ImageID: do.ImageID,
+						Error:   e,
+					}
+					return
+				}
+			}
+
 			ch <- internal.MachineStatus{
+				ImageID:      do.ImageID,
 				Status:       image.Status,
 				IsImageReady: image.Status == "available",
-				Error:        e,
 			}
 
-			if e != nil {
-				return
-			} else if image.Status == "available" {
+			if image.Status == "available" {
 				break
 			}
 		}
@@ -122,8 +192,18 @@ func (do *Digitalocean) SetupMachine(ch chan internal.MachineStatus, attrs inter
 		}
 
 		do.DropletID = droplet.ID
+
+		if e = do.saveMachine(); e != nil {
+
+			ch <- internal.MachineStatus{
+				Error: e,
+			}
+			return
+		}
 	}
 
+	do.Project.MachineID = do.DropletID
+
 	// Wait for droplet to be ready
 	for {
 
@@ -150,16 +230,30 @@ func (do *Digitalocean) SetupMachine(ch chan internal.MachineStatus, attrs inter
 			do.Project.Addr, _ = droplet.PublicIPv4()
 		}
 
+		// Record the machine before reporting it ready, the receiver
+		// stops reading once it's ready.
+		if droplet.Status == "active" {
+
+			machine := dropletToMachine(droplet)
+			do.Project.Machine = &machine
+
+			if e = do.saveMachine(); e != nil {
+
+				ch <- internal.MachineStatus{
+					IsImageInstalled: true,
+					Error:            e,
+				}
+				return
+			}
+		}
+
 		ch <- internal.MachineStatus{
 			Status:           droplet.Status,
 			IsImageInstalled: true,
 			IsMachineReady:   droplet.Status == "active",
-			Error:            e,
 		}
 
-		if e != nil {
-			return
-		} else if droplet.Status == "active" {
+		if droplet.Status == "active" {
 			break
 		}
 
@@ -167,6 +261,34 @@ func (do *Digitalocean) SetupMachine(ch chan internal.MachineStatus, attrs inter
 	}
 }
 
+// Record created image in project state.
+func (do *Digitalocean) saveImage(status string) error {
+
+	return do.Project.State.Update(do.Project.StateName(), func(p *internal.ProjectState) {
+		p.SetImage(internal.ImageState{
+			ID:     do.ImageID,
+			Name:   do.Project.Name,
+			From:   do.Project.Config.Image.From,
+			Status: status,
+		})
+	})
+}
+
+// Record created droplet in project state.
+func (do *Digitalocean) saveMachine() error {
+
+	return do.Project.State.Update(do.Project.StateName(), func(p *internal.ProjectState) {
+		p.SetMachine(internal.MachineState{
+			ID:       do.DropletID,
+			Name:     do.Project.Name,
+			Addr:     do.Project.Addr,
+			User:     do.Project.User,
+			Provider: "digitalocean",
+			ImageID:  do.ImageID,
+		})
+	})
+}
+
 func (do Digitalocean) CreateCustomImage(ImageRequest *godo.CustomImageCreateRequest) (image *godo.Image, err error) {
 
 	image, _, err = do.DoClient.Images.Create(context.TODO(), ImageRequest)
@@ -176,16 +298,28 @@ func (do Digitalocean) CreateCustomImage(ImageRequest *godo.CustomImageCreateReq
 
 func (do *Digitalocean) CreateDroplet(image godo.DropletCreateImage) (*godo.Droplet, error) {
 
+	userData, e := do.pinHostKey()
+
+	if e != nil {
+		return nil, e
+	}
+
 	dropletRequest := &godo.DropletCreateRequest{
-		Name:   do.Project.Name,
-		Region: do.Project.Config.Image.Region,
-		Size:   do.Project.Config.Image.Size,
-		Image:  image,
-		Tags:   []string{"apker", "api"},
+		Name:     do.Project.Name,
+		Region:   do.Project.Config.Image.Region,
+		Size:     do.Project.Config.Image.Size,
+		Image:    image,
+		Tags:     []string{internal.Tag, "api"},
+		UserData: userData,
 	}
 
-	// Key should be exists on digitalocean.
+	// Password auth has no key.
 	if do.Project.PublicKey.Fingerprint != "" {
+
+		if e = do.registerKey(); e != nil {
+			return nil, e
+		}
+
 		dropletRequest.SSHKeys = []godo.DropletCreateSSHKey{
 			godo.DropletCreateSSHKey{
 				Fingerprint: do.Project.PublicKey.Fingerprint,
@@ -198,6 +332,297 @@ func (do *Digitalocean) CreateDroplet(image godo.DropletCreateImage) (*godo.Drop
 	return droplet, err
 }
 
+// Add the project public key to digitalocean keys when missing.
+func (do *Digitalocean) registerKey() (e error) {
+
+	var (
+		key *godo.Key
+		res *godo.Response
+		fp  string = do.Project.PublicKey.Fingerprint
+	)
+
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	if _, res, e = do.DoClient.Keys.GetByFingerprint(context.TODO(), fp); e == nil {
+		return
+	} else if res == nil || res.StatusCode != http.StatusNotFound {
+		return
+	}
+
+	key, _, e = do.DoClient.Keys.Create(context.TODO(), &godo.KeyCreateRequest{
+		Name:      do.Project.Name,
+		PublicKey: do.Project.PublicKey.Key,
+	})
+
+	if e != nil {
+		return fmt.Errorf("Register ssh key %s error: %s", fp, e.Error())
+	}
+
+	do.KeyID = key.ID
+	return
+}
+
+// Remove the ssh key registered by apker, machines keep it.
+func (do *Digitalocean) Cleanup() (e error) {
+
+	if do.KeyID != 0 {
+
+		if _, e = do.DoClient.Keys.DeleteByID(context.TODO(), do.KeyID); e == nil {
+			do.KeyID = 0
+		}
+	}
+
+	return
+}
+
+// Generate the droplet host key and get the cloud-init user data that installs it,
+// other host keys are not generated so ssh clients can only negotiate this one.
+func (do *Digitalocean) pinHostKey() (string, error) {
+
+	// Host key is trusted on first use.
+	if do.Project.NoPinHostKey {
+		return "", nil
+	}
+
+	private, public, e := utils.GenerateKey("apker@" + do.Project.Name)
+
+	if e != nil {
+		return "", e
+	}
+
+	do.Project.HostKey = public
+
+	return fmt.Sprintf(`#cloud-config
+ssh_deletekeys: true
+ssh_genkeytypes: []
+ssh_keys:
+  ed25519_private: |
+    %s
+  ed25519_public: %s
+`, strings.ReplaceAll(strings.TrimSpace(string(private)), "\n", "\n    "), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(public)))), nil
+}
+
+func (do *Digitalocean) Get(id int) (m internal.Machine, e error) {
+
+	droplet, _, e := do.DoClient.Droplets.Get(context.TODO(), id)
+
+	if e != nil {
+		return
+	}
+
+	m = dropletToMachine(droplet)
+	return
+}
+
+func (do *Digitalocean) List(tag string) (machines []internal.Machine, e error) {
+
+	var (
+		droplets []godo.Droplet
+		res      *godo.Response
+		opt      = &godo.ListOptions{Page: 1, PerPage: 200}
+	)
+
+	for {
+
+		if tag == "" {
+			droplets, res, e = do.DoClient.Droplets.List(context.TODO(), opt)
+		} else {
+			droplets, res, e = do.DoClient.Droplets.ListByTag(context.TODO(), tag, opt)
+		}
+
+		if e != nil {
+			return
+		}
+
+		for i := range droplets {
+			machines = append(machines, dropletToMachine(&droplets[i]))
+		}
+
+		if res.Links == nil || res.Links.IsLastPage() {
+			break
+		}
+
+		opt.Page++
+	}
+
+	return
+}
+
+func (do *Digitalocean) Destroy(id int) (e error) {
+
+	_, e = do.DoClient.Droplets.Delete(context.TODO(), id)
+	return
+}
+
+func (do *Digitalocean) PowerOff(id int) error {
+
+	return do.waitAction(do.DoClient.DropletActions.PowerOff(context.TODO(), id))
+}
+
+func (do *Digitalocean) PowerOn(id int) error {
+
+	return do.waitAction(do.DoClient.DropletActions.PowerOn(context.TODO(), id))
+}
+
+func (do *Digitalocean) PowerCycle(id int) error {
+
+	return do.waitAction(do.DoClient.DropletActions.PowerCycle(context.TODO(), id))
+}
+
+func (do *Digitalocean) Resize(id int, size string) error {
+
+	return do.waitAction(do.DoClient.DropletActions.Resize(context.TODO(), id, size, false))
+}
+
+func (do *Digitalocean) Snapshot(id int, name string) error {
+
+	return do.waitAction(do.DoClient.DropletActions.Snapshot(context.TODO(), id, name))
+}
+
+func (do *Digitalocean) Images(tag string) (images []internal.Image, e error) {
+
+	var (
+		list []godo.Image
+		res  *godo.Response
+		opt  = &godo.ListOptions{Page: 1, PerPage: 200}
+	)
+
+	for {
+
+		if list, res, e = do.DoClient.Images.ListUser(context.TODO(), opt); e != nil {
+			return
+		}
+
+		for _, image := range list {
+
+			// Images created by older apker versions have no tags.
+			if tag != "" && !hasTag(image.Tags, tag) && !(tag == internal.Tag && image.Description == imageDescription) {
+				continue
+			}
+
+			images = append(images, internal.Image{
+				ID:      image.ID,
+				Name:    image.Name,
+				Status:  image.Status,
+				Created: image.Created,
+			})
+		}
+
+		if res.Links == nil || res.Links.IsLastPage() {
+			break
+		}
+
+		opt.Page++
+	}
+
+	return
+}
+
+func (do *Digitalocean) DestroyImage(id int) (e error) {
+
+	_, e = do.DoClient.Images.Delete(context.TODO(), id)
+	return
+}
+
+// Wait for droplet action to be completed.
+func (do *Digitalocean) waitAction(action *godo.Action, _ *godo.Response, e error) error {
+
+	for e == nil {
+
+		switch action.Status {
+		case godo.ActionCompleted:
+			return nil
+		case "errored":
+			return fmt.Errorf("Droplet %d action %s errored.", action.ResourceID, action.Type)
+		}
+
+		time.Sleep(3 * time.Second)
+
+		action, _, e = do.DoClient.Actions.Get(context.TODO(), action.ID)
+	}
+
+	return e
+}
+
+func hasTag(tags []string, tag string) bool {
+
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dropletToMachine(droplet *godo.Droplet) internal.Machine {
+
+	m := internal.Machine{
+		ID:      droplet.ID,
+		Name:    droplet.Name,
+		Status:  droplet.Status,
+		Size:    droplet.SizeSlug,
+		Tags:    droplet.Tags,
+		Created: droplet.Created,
+	}
+
+	m.Addr, _ = droplet.PublicIPv4()
+
+	if droplet.Region != nil {
+		m.Region = droplet.Region.Slug
+	}
+
+	if droplet.Image != nil {
+
+		if m.Image = droplet.Image.Slug; m.Image == "" {
+			m.Image = fmt.Sprintf("%s (%d)", droplet.Image.Name, droplet.Image.ID)
+		}
+	}
+
+	return m
+}
+
+func (do Digitalocean) Sizes() (sizes []internal.Size, e error) {
+
+	list, _, e := do.DoClient.Sizes.List(context.TODO(), nil)
+
+	if e != nil {
+		return
+	}
+
+	for _, size := range list {
+		sizes = append(sizes, internal.Size{
+			Slug:         size.Slug,
+			Memory:       size.Memory,
+			Vcpus:        size.Vcpus,
+			Disk:         size.Disk,
+			PriceMonthly: size.PriceMonthly,
+		})
+	}
+
+	return
+}
+
+func (do Digitalocean) Regions() (regions []internal.Region, e error) {
+
+	list, _, e := do.DoClient.Regions.List(context.TODO(), nil)
+
+	if e != nil {
+		return
+	}
+
+	for _, r := range list {
+		regions = append(regions, internal.Region{
+			Slug:      r.Slug,
+			Name:      r.Name,
+			Available: r.Available,
+		})
+	}
+
+	return
+}
+
 func NewDigitalocean(p *internal.Project) (*Digitalocean, error) {
 
 	if _, ok := p.Config.Provider.Credentials["API_KEY"]; !ok {
@@ -0,0 +1,137 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+type ProviderConstructor func(*Project) (Provider, error)
+
+type Capabilities struct {
+
+	// Provider creates machines, when false the machine address is required.
+	Provision bool
+
+	// Provider can create images from a remote url.
+	CustomImages bool
+}
+
+type Credential struct {
+	Name     string
+	Env      string
+	Usage    string
+	Required bool
+}
+
+type ProviderInfo struct {
+	Name         string
+	New          ProviderConstructor
+	Capabilities Capabilities
+	Credentials  []Credential
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ProviderInfo)
+)
+
+// Register a provider by name, providers should call it from their init func.
+// It panics if the same name registered twice or if the constructor is nil.
+func RegisterProvider(info ProviderInfo) {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if info.New == nil {
+		panic("apker: RegisterProvider constructor is nil for " + info.Name)
+	}
+
+	if _, dup := registry[info.Name]; dup {
+		panic("apker: RegisterProvider called twice for " + info.Name)
+	}
+
+	registry[info.Name] = info
+}
+
+// Get registered provider info by name.
+func LookupProvider(name string) (info ProviderInfo, e error) {
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	info, ok := registry[name]
+
+	if !ok {
+		e = fmt.Errorf("Unknown provider name: %s (available: %v)", name, providerNames())
+	}
+
+	return
+}
+
+// Get sorted names of all registered providers.
+func ProviderNames() []string {
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return providerNames()
+}
+
+func providerNames() (names []string) {
+
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return
+}
+
+// Resolve project provider from apker.yaml through the registry.
+func NewProvider(project *Project) (p Provider, info ProviderInfo, e error) {
+
+	if info, e = LookupProvider(project.Config.Provider.Name); e != nil {
+		return
+	}
+
+	if project.Config.Provider.Credentials == nil {
+		project.Config.Provider.Credentials = make(map[string]string)
+	}
+
+	// Fallback to credentials env vars.
+	for _, c := range info.Credentials {
+
+		if project.Config.Provider.Credentials[c.Name] == "" && c.Env != "" {
+			project.Config.Provider.Credentials[c.Name] = os.Getenv(c.Env)
+		}
+	}
+
+	if e = info.checkCredentials(project.Config.Provider.Credentials); e != nil {
+		return
+	}
+
+	p, e = info.New(project)
+	return
+}
+
+func (info ProviderInfo) checkCredentials(creds map[string]string) error {
+
+	for _, c := range info.Credentials {
+
+		if c.Required && creds[c.Name] == "" {
+
+			if c.Env != "" {
+				return fmt.Errorf("%s credential is required for %s provider (export %s=%s).", c.Name, info.Name, c.Env, c.Usage)
+			}
+
+			return fmt.Errorf("%s credential is required for %s provider.", c.Name, info.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/unleashable/apker/internal/utils"
+)
+
+// Deploy strategies.
+const (
+	StrategyParallel = "parallel"
+	StrategyRolling  = "rolling"
+	StrategyCanary   = "canary"
+)
+
+type Strategy struct {
+	Name  string `yaml:"name"`
+	Batch int    `yaml:"batch"`
+}
+
+func (s Strategy) Validate() error {
+
+	switch s.Name {
+	case "", StrategyParallel, StrategyRolling, StrategyCanary:
+	default:
+		return fmt.Errorf("Unknown deploy strategy: %s", s.Name)
+	}
+
+	if s.Batch < 0 {
+		return fmt.Errorf("Invalid deploy strategy batch: %d", s.Batch)
+	}
+
+	return nil
+}
+
+// Split n machines indexes to deploy batches.
+func (s Strategy) Batches(n int) (batches [][]int) {
+
+	var (
+		start int
+		size  int = s.Batch
+	)
+
+	switch s.Name {
+	case StrategyRolling:
+
+		if size == 0 {
+			size = 1
+		}
+
+	case StrategyCanary:
+
+		if n > 0 {
+			batches = append(batches, []int{0})
+			start = 1
+		}
+
+		if size == 0 {
+			size = n
+		}
+
+	default:
+		size = n
+	}
+
+	for i := start; i < n; i += size {
+
+		batch := []int{}
+
+		for j := i; j < i+size && j < n; j++ {
+			batch = append(batch, j)
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return
+}
+
+// Run fn on n machines batch by batch, stops at the first failed batch.
+func (s Strategy) Rollout(n int, fn func(i int) error) error {
+
+	for b, batch := range s.Batches(n) {
+
+		var (
+			mu     sync.Mutex
+			failed []int
+		)
+
+		utils.Parallel(len(batch), 0, func(i int) {
+
+			if fn(batch[i]) != nil {
+				mu.Lock()
+				failed = append(failed, batch[i])
+				mu.Unlock()
+			}
+		})
+
+		if len(failed) > 0 {
+			return fmt.Errorf("Rollout stopped: batch %d failed on %d of %d machines.", b+1, len(failed), len(batch))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestStrategyBatches(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		strategy Strategy
+		n        int
+		batches  [][]int
+	}{
+		{"default", Strategy{}, 3, [][]int{{0, 1, 2}}},
+		{"parallel", Strategy{Name: StrategyParallel, Batch: 1}, 3, [][]int{{0, 1, 2}}},
+		{"rolling", Strategy{Name: StrategyRolling}, 3, [][]int{{0}, {1}, {2}}},
+		{"rolling batch", Strategy{Name: StrategyRolling, Batch: 2}, 5, [][]int{{0, 1}, {2, 3}, {4}}},
+		{"rolling batch too large", Strategy{Name: StrategyRolling, Batch: 10}, 3, [][]int{{0, 1, 2}}},
+		{"canary", Strategy{Name: StrategyCanary}, 4, [][]int{{0}, {1, 2, 3}}},
+		{"canary batch", Strategy{Name: StrategyCanary, Batch: 2}, 6, [][]int{{0}, {1, 2}, {3, 4}, {5}}},
+		{"canary one machine", Strategy{Name: StrategyCanary}, 1, [][]int{{0}}},
+		{"no machines", Strategy{Name: StrategyCanary}, 0, nil},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			if got := c.strategy.Batches(c.n); !reflect.DeepEqual(got, c.batches) {
+				t.Errorf("got %v, want %v", got, c.batches)
+			}
+		})
+	}
+}
+
+func TestStrategyValidate(t *testing.T) {
+
+	for _, s := range []Strategy{{}, {Name: StrategyParallel}, {Name: StrategyRolling, Batch: 2}, {Name: StrategyCanary}} {
+
+		if e := s.Validate(); e != nil {
+			t.Errorf("%+v: %s", s, e)
+		}
+	}
+
+	for _, s := range []Strategy{{Name: "blue-green"}, {Name: StrategyRolling, Batch: -1}} {
+
+		if s.Validate() == nil {
+			t.Errorf("%+v: expected error", s)
+		}
+	}
+}
+
+func TestStrategyRollout(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		strategy Strategy
+		n        int
+		fail     map[int]bool
+		deployed []int
+		err      bool
+	}{
+		{"all succeed", Strategy{Name: StrategyRolling}, 3, nil, []int{0, 1, 2}, false},
+		{"rolling stops at failed batch", Strategy{Name: StrategyRolling}, 4, map[int]bool{1: true}, []int{0, 1}, true},
+		{"canary failure stops the rest", Strategy{Name: StrategyCanary}, 4, map[int]bool{0: true}, []int{0}, true},
+		{"failed batch finishes", Strategy{Name: StrategyCanary, Batch: 2}, 5, map[int]bool{1: true}, []int{0, 1, 2}, true},
+		{"parallel runs all", Strategy{}, 3, map[int]bool{0: true}, []int{0, 1, 2}, true},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			var (
+				mu       sync.Mutex
+				deployed []int
+			)
+
+			e := c.strategy.Rollout(c.n, func(i int) error {
+
+				mu.Lock()
+				deployed = append(deployed, i)
+				mu.Unlock()
+
+				if c.fail[i] {
+					return errors.New("failed")
+				}
+
+				return nil
+			})
+
+			if c.err != (e != nil) {
+				t.Fatalf("unexpected error: %v", e)
+			}
+
+			sort.Ints(deployed)
+
+			if !reflect.DeepEqual(deployed, c.deployed) {
+				t.Errorf("deployed %v, want %v", deployed, c.deployed)
+			}
+		})
+	}
+}
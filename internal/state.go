@@ -0,0 +1,343 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Max deploy records to keep per project.
+const maxDeployRecords = 50
+
+type MachineState struct {
+	ID       int       `json:"id"`
+	Name     string    `json:"name"`
+	Addr     string    `json:"addr"`
+	User     string    `json:"user"`
+	Provider string    `json:"provider"`
+	ImageID  int       `json:"image_id"`
+	Key      string    `json:"key,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+type ImageState struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	From    string    `json:"from"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+}
+
+type DeployRecord struct {
+	RunID     string    `json:"run_id"`
+	MachineID int       `json:"machine_id"`
+	Addr      string    `json:"addr"`
+	Repo      string    `json:"repo"`
+	Ref       string    `json:"ref,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type ProjectState struct {
+	Machines []MachineState `json:"machines"`
+	Images   []ImageState   `json:"images"`
+	Deploys  []DeployRecord `json:"deploys"`
+}
+
+type State struct {
+	mu       sync.Mutex
+	path     string
+	Projects map[string]*ProjectState `json:"projects"`
+}
+
+// Get apker state directory, $XDG_STATE_HOME/apker by default.
+func StateDir() string {
+
+	dir := os.Getenv("XDG_STATE_HOME")
+
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+
+	return filepath.Join(dir, "apker")
+}
+
+// Get state file path, $XDG_STATE_HOME/apker/state.json by default.
+func StatePath() string {
+
+	if p := os.Getenv("APKER_STATE"); p != "" {
+		return p
+	}
+
+	return filepath.Join(StateDir(), "state.json")
+}
+
+// Load state from the state file, missing file means empty state.
+func LoadState() (s *State, e error) {
+
+	var data []byte
+
+	s = &State{
+		path:     StatePath(),
+		Projects: make(map[string]*ProjectState),
+	}
+
+	if data, e = ioutil.ReadFile(s.path); os.IsNotExist(e) {
+		return s, nil
+	} else if e != nil {
+		return
+	}
+
+	if e = json.Unmarshal(data, s); e == nil && s.Projects == nil {
+		s.Projects = make(map[string]*ProjectState)
+	}
+
+	return
+}
+
+// Get a copy of project state.
+func (s *State) Project(name string) (p ProjectState) {
+
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ps, ok := s.Projects[name]; ok {
+		p = *ps
+	}
+
+	return
+}
+
+// Update project state and save it, it's a no-op on nil state.
+func (s *State) Update(name string, fn func(*ProjectState)) error {
+
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Projects[name]; !ok {
+		s.Projects[name] = &ProjectState{}
+	}
+
+	fn(s.Projects[name])
+
+	return s.save()
+}
+
+// Update all projects state and save it.
+func (s *State) UpdateAll(fn func(*ProjectState)) error {
+
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.Projects {
+		fn(p)
+	}
+
+	return s.save()
+}
+
+func (s *State) save() (e error) {
+
+	var data []byte
+
+	defer func() {
+		if e != nil {
+			e = fmt.Errorf("Save state %s error: %s", s.path, e.Error())
+		}
+	}()
+
+	if data, e = json.MarshalIndent(s, "", "  "); e != nil {
+		return
+	}
+
+	if e = os.MkdirAll(filepath.Dir(s.path), 0700); e != nil {
+		return
+	}
+
+	// Write to temp file first, so a crash never leaves a broken state.
+	if e = ioutil.WriteFile(s.path+".tmp", data, 0600); e != nil {
+		return
+	}
+
+	return os.Rename(s.path+".tmp", s.path)
+}
+
+// Add or update machine by id, or by address for machines without id.
+func (p *ProjectState) SetMachine(m MachineState) {
+
+	for i := range p.Machines {
+
+		if p.Machines[i].ID == m.ID && (m.ID != 0 || p.Machines[i].Addr == m.Addr) {
+
+			if m.Created.IsZero() {
+				m.Created = p.Machines[i].Created
+			}
+
+			if m.ImageID == 0 {
+				m.ImageID = p.Machines[i].ImageID
+			}
+
+			if m.Key == "" {
+				m.Key = p.Machines[i].Key
+			}
+
+			p.Machines[i] = m
+			return
+		}
+	}
+
+	if m.Created.IsZero() {
+		m.Created = time.Now()
+	}
+
+	p.Machines = append(p.Machines, m)
+}
+
+// Remove machine by id and its stored deploy key.
+func (p *ProjectState) RemoveMachine(id int) {
+
+	for i := range p.Machines {
+
+		if p.Machines[i].ID == id {
+
+			if p.Machines[i].Key != "" {
+				os.Remove(p.Machines[i].Key)
+			}
+
+			p.Machines = append(p.Machines[:i], p.Machines[i+1:]...)
+			return
+		}
+	}
+}
+
+// Get last created machine.
+func (p ProjectState) Machine() (m MachineState, ok bool) {
+
+	if ok = len(p.Machines) > 0; ok {
+		m = p.Machines[len(p.Machines)-1]
+	}
+
+	return
+}
+
+// Get machine of the last successful deploy.
+func (p ProjectState) DeployedMachine() (m MachineState, ok bool) {
+
+	for i := len(p.Deploys) - 1; i >= 0; i-- {
+
+		if !p.Deploys[i].Success {
+			continue
+		}
+
+		for _, m = range p.Machines {
+
+			if (m.ID != 0 && m.ID == p.Deploys[i].MachineID) || (m.ID == 0 && m.Addr == p.Deploys[i].Addr) {
+				return m, true
+			}
+		}
+	}
+
+	return MachineState{}, false
+}
+
+// Get machine by name.
+func (p ProjectState) MachineByName(name string) (m MachineState, ok bool) {
+
+	for i := len(p.Machines) - 1; i >= 0; i-- {
+
+		if p.Machines[i].Name == name {
+			return p.Machines[i], true
+		}
+	}
+
+	return
+}
+
+// Add or update image by id.
+func (p *ProjectState) SetImage(img ImageState) {
+
+	for i := range p.Images {
+
+		if p.Images[i].ID == img.ID {
+
+			if img.Created.IsZero() {
+				img.Created = p.Images[i].Created
+			}
+
+			p.Images[i] = img
+			return
+		}
+	}
+
+	if img.Created.IsZero() {
+		img.Created = time.Now()
+	}
+
+	p.Images = append(p.Images, img)
+}
+
+func (p *ProjectState) RemoveImage(id int) {
+
+	for i := range p.Images {
+
+		if p.Images[i].ID == id {
+			p.Images = append(p.Images[:i], p.Images[i+1:]...)
+			return
+		}
+	}
+}
+
+// Get last image created from a source.
+func (p ProjectState) Image(from string) (img ImageState, ok bool) {
+
+	for i := len(p.Images) - 1; i >= 0; i-- {
+
+		if p.Images[i].From == from {
+			return p.Images[i], true
+		}
+	}
+
+	return
+}
+
+func (p *ProjectState) AddDeploy(r DeployRecord) {
+
+	if p.Deploys = append(p.Deploys, r); len(p.Deploys) > maxDeployRecords {
+		p.Deploys = p.Deploys[len(p.Deploys)-maxDeployRecords:]
+	}
+}
+
+// Get last deploy on a machine by id or address.
+func (p ProjectState) LastDeploy(id int, addr string) (r DeployRecord, ok bool) {
+
+	for i := len(p.Deploys) - 1; i >= 0; i-- {
+
+		if (id != 0 && p.Deploys[i].MachineID == id) || (addr != "" && p.Deploys[i].Addr == addr) {
+			return p.Deploys[i], true
+		}
+	}
+
+	return
+}
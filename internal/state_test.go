@@ -0,0 +1,42 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import "testing"
+
+func TestDeployedMachine(t *testing.T) {
+
+	machines := []MachineState{
+		{ID: 1, Name: "a", Addr: "10.0.0.1"},
+		{ID: 2, Name: "b", Addr: "10.0.0.2"},
+		{Name: "c", Addr: "10.0.0.3"},
+	}
+
+	cases := []struct {
+		name    string
+		deploys []DeployRecord
+		machine string
+	}{
+		{"no deploys", nil, ""},
+		{"only failed", []DeployRecord{{MachineID: 1, Success: false}}, ""},
+		{"last success", []DeployRecord{{MachineID: 1, Success: true}, {MachineID: 2, Success: true}}, "b"},
+		{"skips failed", []DeployRecord{{MachineID: 1, Success: true}, {MachineID: 2, Success: false}}, "a"},
+		{"by addr", []DeployRecord{{MachineID: 1, Success: true}, {Addr: "10.0.0.3", Success: true}}, "c"},
+		{"unreachable addr", []DeployRecord{{MachineID: 2, Success: true}, {Addr: "10.0.0.9", Success: false}}, "b"},
+		{"destroyed machine", []DeployRecord{{MachineID: 1, Success: true}, {MachineID: 5, Success: true}}, "a"},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			p := ProjectState{Machines: machines, Deploys: c.deploys}
+			m, ok := p.DeployedMachine()
+
+			if ok != (c.machine != "") || m.Name != c.machine {
+				t.Errorf("got %q (%v), want %q", m.Name, ok, c.machine)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Deploy step types.
+const (
+	StepRun      = "run"
+	StepCopy     = "copy"
+	StepDir      = "dir"
+	StepUpload   = "upload"
+	StepTemplate = "template"
+	StepReboot   = "reboot"
+)
+
+// Deploy step, from the string shorthand (e.g: "copy src dst")
+// or the map form (e.g: {copy: {src: a, dst: b, mode: "0644"}}).
+type Step struct {
+	Type string
+	Run  string
+	Cwd  string
+	Env  map[string]string
+	User string
+	Copy CopyStep
+	Dirs []string
+
+	// Local files to upload, src is relative to the project path.
+	Upload CopyStep
+
+	// Repository template to render, src is relative to the repository root.
+	Template CopyStep
+
+	Raw   string
+	Error error
+}
+
+type CopyStep struct {
+	Src   string `yaml:"src"`
+	Dst   string `yaml:"dst"`
+	Mode  string `yaml:"mode"`
+	Owner string `yaml:"owner"`
+}
+
+type stepMap struct {
+	Run      string            `yaml:"run"`
+	Cwd      string            `yaml:"cwd"`
+	Env      map[string]string `yaml:"env"`
+	User     string            `yaml:"user"`
+	Copy     *CopyStep         `yaml:"copy"`
+	Dir      string            `yaml:"dir"`
+	Upload   *CopyStep         `yaml:"upload"`
+	Template *CopyStep         `yaml:"template"`
+	Reboot   bool              `yaml:"reboot"`
+}
+
+var stepKeys = map[string]bool{
+	"run": true, "cwd": true, "env": true, "user": true, "copy": true, "dir": true, "upload": true, "template": true, "reboot": true,
+}
+
+// Parse step from yaml, errors are kept in step.Error so
+// config validation can report the step index.
+func (s *Step) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	var (
+		raw  string
+		keys map[string]interface{}
+		m    stepMap
+	)
+
+	if unmarshal(&raw) == nil {
+		*s = ParseStep(raw)
+		return nil
+	}
+
+	if s.Error = unmarshal(&keys); s.Error != nil {
+		return nil
+	}
+
+	for k := range keys {
+		if !stepKeys[k] {
+			s.Error = fmt.Errorf("unknown key: %s", k)
+			return nil
+		}
+	}
+
+	if s.Error = unmarshal(&m); s.Error != nil {
+		return nil
+	}
+
+	*s = Step{
+		Run:  m.Run,
+		Cwd:  m.Cwd,
+		Env:  m.Env,
+		User: m.User,
+	}
+
+	types := []string{}
+
+	if m.Run != "" {
+		types = append(types, StepRun)
+	}
+
+	if m.Copy != nil {
+		s.Copy = *m.Copy
+		types = append(types, StepCopy)
+	}
+
+	if m.Dir != "" {
+		s.Dirs = []string{m.Dir}
+		types = append(types, StepDir)
+	}
+
+	if m.Upload != nil {
+		s.Upload = *m.Upload
+		types = append(types, StepUpload)
+	}
+
+	if m.Template != nil {
+		s.Template = *m.Template
+		types = append(types, StepTemplate)
+	}
+
+	if m.Reboot {
+		types = append(types, StepReboot)
+	}
+
+	if len(types) != 1 {
+		s.Error = fmt.Errorf("step should have exactly one of: run, copy, dir, upload, template, reboot")
+		return nil
+	}
+
+	s.Type = types[0]
+	s.Raw = s.String()
+
+	// Only run steps use cwd, env and user, don't ignore them silently.
+	if s.Type != StepRun {
+
+		unsupported := []string{}
+
+		for _, k := range []string{"cwd", "env", "user"} {
+			if _, ok := keys[k]; ok {
+				unsupported = append(unsupported, k)
+			}
+		}
+
+		if len(unsupported) > 0 {
+			s.Error = fmt.Errorf("%s step doesn't support %s: %s", s.Type, strings.Join(unsupported, ", "), s.Raw)
+		}
+	}
+
+	return nil
+}
+
+// Copy or upload step from a string "src dst" or a map.
+func (c *CopyStep) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	var raw string
+
+	if unmarshal(&raw) == nil {
+		return c.parse(raw)
+	}
+
+	type copyStep CopyStep
+	return unmarshal((*copyStep)(c))
+}
+
+func (c *CopyStep) parse(raw string) error {
+
+	args, e := splitArgs(raw)
+
+	if e != nil {
+		return e
+	} else if len(args) != 2 {
+		return fmt.Errorf("requires src and dst: %s", raw)
+	}
+
+	c.Src, c.Dst = args[0], args[1]
+	return nil
+}
+
+func (c CopyStep) validate() error {
+
+	if c.Src == "" || c.Dst == "" {
+		return fmt.Errorf("requires src and dst")
+	}
+
+	if c.Mode != "" {
+
+		if _, e := strconv.ParseUint(c.Mode, 8, 32); e != nil {
+			return fmt.Errorf("invalid mode: %s", c.Mode)
+		}
+	}
+
+	return nil
+}
+
+// Parse step string shorthand, copy paths can be quoted.
+func ParseStep(raw string) (s Step) {
+
+	s.Raw = raw
+	parts := strings.SplitN(strings.TrimSpace(raw), " ", 2)
+	s.Type = parts[0]
+
+	var rest string
+
+	if len(parts) > 1 {
+		rest = strings.TrimSpace(parts[1])
+	}
+
+	switch s.Type {
+	case StepRun:
+		s.Run = rest
+
+	case StepDir:
+		s.Dirs, s.Error = splitArgs(rest)
+
+	case StepCopy:
+		s.Error = s.Copy.parse(rest)
+
+	case StepUpload:
+		s.Error = s.Upload.parse(rest)
+
+	case StepTemplate:
+		s.Error = s.Template.parse(rest)
+
+	case StepReboot:
+
+		if rest != "" {
+			s.Error = fmt.Errorf("reboot step takes no arguments")
+		}
+	}
+
+	return
+}
+
+func (s Step) Validate() error {
+
+	if s.Error != nil {
+		return s.Error
+	}
+
+	switch s.Type {
+	case StepRun:
+
+		if s.Run == "" {
+			return fmt.Errorf("run step command is empty")
+		}
+
+	case StepDir:
+
+		if len(s.Dirs) == 0 {
+			return fmt.Errorf("dir step path is empty")
+		}
+
+	case StepCopy:
+
+		if e := s.Copy.validate(); e != nil {
+			return fmt.Errorf("copy step %s", e.Error())
+		}
+
+	case StepUpload:
+
+		if e := s.Upload.validate(); e != nil {
+			return fmt.Errorf("upload step %s", e.Error())
+		}
+
+	case StepTemplate:
+
+		if e := s.Template.validate(); e != nil {
+			return fmt.Errorf("template step %s", e.Error())
+		}
+
+	case StepReboot:
+	default:
+		return fmt.Errorf("unknown step type: %s", s.Type)
+	}
+
+	return nil
+}
+
+func (s Step) String() string {
+
+	if s.Raw != "" {
+		return s.Raw
+	}
+
+	switch s.Type {
+	case StepRun:
+		return "run " + s.Run
+	case StepCopy:
+		return fmt.Sprintf("copy %s %s", s.Copy.Src, s.Copy.Dst)
+	case StepDir:
+		return "dir " + strings.Join(s.Dirs, " ")
+	case StepUpload:
+		return fmt.Sprintf("upload %s %s", s.Upload.Src, s.Upload.Dst)
+	case StepTemplate:
+		return fmt.Sprintf("template %s %s", s.Template.Src, s.Template.Dst)
+	}
+
+	return s.Type
+}
+
+// Get sorted env var names.
+func (s Step) envNames() (names []string) {
+
+	for name := range s.Env {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return
+}
+
+// Split string to arguments, supports single and double quotes.
+func splitArgs(s string) (args []string, e error) {
+
+	var (
+		arg    strings.Builder
+		quote  rune
+		inArg  bool
+		escape bool
+	)
+
+	for _, r := range s {
+
+		switch {
+		case escape:
+			arg.WriteRune(r)
+			escape = false
+
+		case r == '\\' && quote != '\'':
+			escape, inArg = true, true
+
+		case quote != 0:
+
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+
+		case r == ' ' || r == '\t':
+
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in: %s", s)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSplitArgs(t *testing.T) {
+
+	cases := []struct {
+		name string
+		in   string
+		args []string
+		err  bool
+	}{
+		{"empty", "", nil, false},
+		{"plain", "a b", []string{"a", "b"}, false},
+		{"extra spaces", "  a \t b  ", []string{"a", "b"}, false},
+		{"double quotes", `"my dir" b`, []string{"my dir", "b"}, false},
+		{"single quotes", `'it "x"' b`, []string{`it "x"`, "b"}, false},
+		{"escaped space", `my\ dir b`, []string{"my dir", "b"}, false},
+		{"backslash in single quotes", `'a\b'`, []string{`a\b`}, false},
+		{"empty quoted", `"" b`, []string{"", "b"}, false},
+		{"unterminated", `"a b`, nil, true},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			args, e := splitArgs(c.in)
+
+			if c.err != (e != nil) {
+				t.Fatalf("unexpected error: %v", e)
+			}
+
+			if !reflect.DeepEqual(args, c.args) {
+				t.Errorf("got %q, want %q", args, c.args)
+			}
+		})
+	}
+}
+
+func TestParseStep(t *testing.T) {
+
+	cases := []struct {
+		raw  string
+		step Step
+		err  bool
+	}{
+		{"run echo hi", Step{Type: StepRun, Run: "echo hi"}, false},
+		{"run", Step{Type: StepRun}, true},
+		{"dir /a '/b c'", Step{Type: StepDir, Dirs: []string{"/a", "/b c"}}, false},
+		{"dir", Step{Type: StepDir}, true},
+		{"copy . /var/www", Step{Type: StepCopy, Copy: CopyStep{Src: ".", Dst: "/var/www"}}, false},
+		{`copy "my dir" /var/www`, Step{Type: StepCopy, Copy: CopyStep{Src: "my dir", Dst: "/var/www"}}, false},
+		{"copy public/", Step{Type: StepCopy}, true},
+		{"upload dist/ /opt/app", Step{Type: StepUpload, Upload: CopyStep{Src: "dist/", Dst: "/opt/app"}}, false},
+		{"template a.tpl /etc/a", Step{Type: StepTemplate, Template: CopyStep{Src: "a.tpl", Dst: "/etc/a"}}, false},
+		{"reboot", Step{Type: StepReboot}, false},
+		{"reboot now", Step{Type: StepReboot}, true},
+		{"restart nginx", Step{Type: "restart"}, true},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.raw, func(t *testing.T) {
+
+			s := ParseStep(c.raw)
+			e := s.Validate()
+
+			if c.err != (e != nil) {
+				t.Fatalf("unexpected error: %v", e)
+			}
+
+			if c.err {
+				return
+			}
+
+			c.step.Raw = c.raw
+
+			if !reflect.DeepEqual(s, c.step) {
+				t.Errorf("got %+v, want %+v", s, c.step)
+			}
+		})
+	}
+}
+
+func TestStepUnmarshalYAML(t *testing.T) {
+
+	cases := []struct {
+		name string
+		yaml string
+		step Step
+		err  string
+	}{
+		{
+			name: "string",
+			yaml: "copy public/ /var/www",
+			step: Step{Type: StepCopy, Copy: CopyStep{Src: "public/", Dst: "/var/www"}, Raw: "copy public/ /var/www"},
+		},
+		{
+			name: "run",
+			yaml: "{run: make, cwd: /opt, user: www, env: {A: b}}",
+			step: Step{Type: StepRun, Run: "make", Cwd: "/opt", User: "www", Env: map[string]string{"A": "b"}, Raw: "run make"},
+		},
+		{
+			name: "copy map",
+			yaml: "{copy: {src: a b, dst: /c, mode: '0644', owner: www}}",
+			step: Step{Type: StepCopy, Copy: CopyStep{Src: "a b", Dst: "/c", Mode: "0644", Owner: "www"}, Raw: "copy a b /c"},
+		},
+		{
+			name: "copy string",
+			yaml: "{copy: '\"a b\" /c'}",
+			step: Step{Type: StepCopy, Copy: CopyStep{Src: "a b", Dst: "/c"}, Raw: "copy a b /c"},
+		},
+		{
+			name: "dir",
+			yaml: "{dir: /var/log/app}",
+			step: Step{Type: StepDir, Dirs: []string{"/var/log/app"}, Raw: "dir /var/log/app"},
+		},
+		{
+			name: "reboot",
+			yaml: "{reboot: true}",
+			step: Step{Type: StepReboot, Raw: "reboot"},
+		},
+		{name: "unknown key", yaml: "{run: make, sudo: true}", err: "unknown key: sudo"},
+		{name: "no type", yaml: "{cwd: /opt}", err: "exactly one of"},
+		{name: "two types", yaml: "{run: make, dir: /a}", err: "exactly one of"},
+		{name: "bad mode", yaml: "{copy: {src: a, dst: b, mode: rwx}}", err: "invalid mode: rwx"},
+		{name: "copy cwd", yaml: "{copy: a b, cwd: /opt}", err: "copy step doesn't support cwd"},
+		{name: "dir env and user", yaml: "{dir: /a, env: {A: b}, user: www}", err: "dir step doesn't support env, user"},
+		{name: "reboot cwd", yaml: "{reboot: true, cwd: /opt}", err: "reboot step doesn't support cwd"},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			var s Step
+
+			if e := yaml.Unmarshal([]byte(c.yaml), &s); e != nil {
+				t.Fatal(e)
+			}
+
+			e := s.Validate()
+
+			if c.err != "" {
+
+				if e == nil || !strings.Contains(e.Error(), c.err) {
+					t.Fatalf("got error %v, want %q", e, c.err)
+				}
+
+				return
+			}
+
+			if e != nil {
+				t.Fatalf("unexpected error: %s", e)
+			}
+
+			if !reflect.DeepEqual(s, c.step) {
+				t.Errorf("got %+v, want %+v", s, c.step)
+			}
+		})
+	}
+}
+
+func TestCheckSteps(t *testing.T) {
+
+	cases := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"empty", "[]", ""},
+		{"valid", "[run make, {dir: /a}, reboot]", ""},
+		{"string step index", "[run make, copy a, reboot]", "Invalid deploy step #2 (copy a): requires src and dst: a"},
+		{"map step index", "[run make, {copy: a b}, {dir: /a, cwd: /b}]", "Invalid deploy step #3 (dir /a): dir step doesn't support cwd: dir /a"},
+		{"reboot not last", "[reboot, run make]", "Invalid deploy step #1: reboot command should be the last step."},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			var steps []Step
+
+			if e := yaml.Unmarshal([]byte(c.yaml), &steps); e != nil {
+				t.Fatal(e)
+			}
+
+			e := checkSteps(steps)
+
+			if c.err == "" && e != nil {
+				t.Fatalf("unexpected error: %s", e)
+			} else if c.err != "" && (e == nil || e.Error() != c.err) {
+				t.Fatalf("got error %v, want %q", e, c.err)
+			}
+		})
+	}
+}
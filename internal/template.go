@@ -0,0 +1,145 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/sftp"
+)
+
+// Data available to template steps.
+type TemplateData struct {
+	Env     map[string]string
+	Params  map[string]string
+	Machine TemplateMachine
+}
+
+type TemplateMachine struct {
+	ID   int
+	Name string
+	Addr string
+	User string
+
+	// Known only for machines of the provider.
+	region string
+	size   string
+}
+
+// Get machine region, unknown region fails the template.
+func (m TemplateMachine) Region() (string, error) {
+
+	if m.region == "" {
+		return "", fmt.Errorf("Machine %s region is unknown", m.Name)
+	}
+
+	return m.region, nil
+}
+
+// Get machine size, unknown size fails the template.
+func (m TemplateMachine) Size() (string, error) {
+
+	if m.size == "" {
+		return "", fmt.Errorf("Machine %s size is unknown", m.Name)
+	}
+
+	return m.size, nil
+}
+
+func (d Deployment) templateData() TemplateData {
+
+	machine := TemplateMachine{
+		ID:   d.Project.MachineID,
+		Name: d.Project.Name,
+		Addr: d.Project.Addr,
+		User: d.Project.User,
+	}
+
+	if m := d.Project.Machine; m != nil {
+		machine.region, machine.size = m.Region, m.Size
+	}
+
+	return TemplateData{
+		Env:     d.Project.Config.Deploy.Env,
+		Params:  d.Project.Config.Params,
+		Machine: machine,
+	}
+}
+
+// Render repository template and upload it to the machine.
+func (d Deployment) template(step CopyStep) (out []byte, e error) {
+
+	var (
+		data []byte
+		tpl  *template.Template
+	)
+
+	// Templates come from the deployed checkout, the same commit as the code.
+	if d.Project.Local {
+		data, e = ioutil.ReadFile(filepath.Join(d.Project.Path, step.Src))
+	} else {
+		data, e = d.readRemote(path.Join("/tmp/apker", step.Src))
+	}
+
+	if e != nil {
+		return
+	}
+
+	tpl, e = template.New(path.Base(step.Src)).Option("missingkey=error").Funcs(tplFuncs(d.Project.Config.Params)).Parse(string(data))
+
+	if e != nil {
+		return
+	}
+
+	file, e := ioutil.TempFile(d.Project.Temp, "template-*")
+
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	if e = tpl.Execute(file, d.templateData()); e != nil {
+		return
+	}
+
+	if step.Mode == "" {
+		step.Mode = "0644"
+	}
+
+	// Keep the template name when dst is a directory.
+	if dst := step.Dst; dst[len(dst)-1] == '/' {
+		step.Dst = path.Join(dst, path.Base(step.Src))
+	}
+
+	step.Src = file.Name()
+	return d.upload(step)
+}
+
+// Read machine file over sftp.
+func (d Deployment) readRemote(name string) (_ []byte, e error) {
+
+	var (
+		client *sftp.Client
+		file   *sftp.File
+	)
+
+	if client, e = sftp.NewClient(d.SSH.Conn); e != nil {
+		return
+	}
+
+	defer client.Close()
+
+	if file, e = client.Open(name); e != nil {
+		return nil, fmt.Errorf("Template %s: %s", name, e.Error())
+	}
+
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
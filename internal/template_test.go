@@ -0,0 +1,62 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestTemplateMachine(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		tpl     string
+		machine *Machine
+		out     string
+		err     string
+	}{
+		{"provider machine", "{{ .Machine.Name }} {{ .Machine.Region }} {{ .Machine.Size }}", &Machine{Region: "fra1", Size: "s-1vcpu-1gb"}, "web fra1 s-1vcpu-1gb", ""},
+		{"existing host", "{{ .Machine.Addr }} {{ .Machine.User }}", nil, "10.0.0.1 root", ""},
+		{"unknown region", "{{ .Machine.Region }}", nil, "", "Machine web region is unknown"},
+		{"unknown size", "{{ .Machine.Size }}", &Machine{Region: "fra1"}, "", "Machine web size is unknown"},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			var out strings.Builder
+
+			d := Deployment{Project: &Project{
+				Config:  &Config{},
+				Name:    "web",
+				Addr:    "10.0.0.1",
+				User:    "root",
+				Machine: c.machine,
+			}}
+
+			tpl := template.Must(template.New(c.name).Option("missingkey=error").Parse(c.tpl))
+			e := tpl.Execute(&out, d.templateData())
+
+			if c.err != "" {
+
+				if e == nil || !strings.Contains(e.Error(), c.err) {
+					t.Fatalf("got error %v, want %q", e, c.err)
+				}
+
+				return
+			}
+
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			if out.String() != c.out {
+				t.Errorf("got %q, want %q", out.String(), c.out)
+			}
+		})
+	}
+}
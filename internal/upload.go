@@ -0,0 +1,205 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"github.com/unleashable/apker/internal/utils"
+)
+
+// Upload local file or directory to the machine over sftp,
+// files with the same checksum on the machine are skipped.
+func (d Deployment) upload(step CopyStep) (out []byte, e error) {
+
+	var (
+		buf     bytes.Buffer
+		info    os.FileInfo
+		sums    map[string]string
+		client  *sftp.Client
+		mode    uint64
+		skipped int
+		src     string = step.Src
+		dst     string = step.Dst
+	)
+
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(d.Project.Path, src)
+	}
+
+	if info, e = os.Stat(src); e != nil {
+		return
+	}
+
+	// Like cp, a file uploaded to a directory path keeps its name.
+	if !info.IsDir() && strings.HasSuffix(dst, "/") {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	if step.Mode != "" {
+
+		if mode, e = strconv.ParseUint(step.Mode, 8, 32); e != nil {
+			return
+		}
+	}
+
+	if sums, e = d.remoteSums(dst); e != nil {
+		return
+	}
+
+	if client, e = sftp.NewClient(d.SSH.Conn); e != nil {
+		return
+	}
+
+	defer client.Close()
+
+	if e = client.MkdirAll(path.Dir(dst)); e != nil {
+		return
+	}
+
+	e = filepath.Walk(src, func(local string, fi os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, local)
+
+		if err != nil {
+			return err
+		}
+
+		remote := path.Join(dst, filepath.ToSlash(rel))
+
+		if fi.IsDir() {
+
+			if err = client.MkdirAll(remote); err != nil {
+				return err
+			}
+
+			return client.Chmod(remote, fi.Mode().Perm())
+		}
+
+		// Symlinks and special files are not uploaded.
+		if !fi.Mode().IsRegular() {
+			fmt.Fprintf(&buf, "ignored: %s\n", local)
+			return nil
+		}
+
+		sum, err := fileSum(local)
+
+		if err != nil {
+			return err
+		}
+
+		if sums[remote] == sum {
+
+			skipped++
+
+		} else if err = uploadFile(client, local, remote); err != nil {
+
+			return err
+
+		} else {
+
+			fmt.Fprintf(&buf, "uploaded: %s\n", remote)
+		}
+
+		perm := fi.Mode().Perm()
+
+		if mode != 0 {
+			perm = os.FileMode(mode)
+		}
+
+		return client.Chmod(remote, perm)
+	})
+
+	if e != nil {
+		return buf.Bytes(), e
+	}
+
+	if step.Owner != "" {
+
+		if out, e = d.SSH.Run("chown -R " + utils.ShellJoin(step.Owner, dst)); e != nil {
+			return append(buf.Bytes(), out...), e
+		}
+	}
+
+	fmt.Fprintf(&buf, "skipped: %d unchanged files\n", skipped)
+	return buf.Bytes(), nil
+}
+
+// Get checksums of remote files under path.
+func (d Deployment) remoteSums(p string) (sums map[string]string, e error) {
+
+	var out []byte
+
+	sums = make(map[string]string)
+	cmd := fmt.Sprintf("find %s -type f -exec sha256sum {} + 2>/dev/null; true", utils.ShellQuote(p))
+
+	if out, e = d.SSH.Run(cmd); e != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+
+		if parts := strings.SplitN(line, "  ", 2); len(parts) == 2 {
+			sums[parts[1]] = parts[0]
+		}
+	}
+
+	return
+}
+
+func uploadFile(client *sftp.Client, local string, remote string) (e error) {
+
+	var (
+		src *os.File
+		dst *sftp.File
+	)
+
+	if src, e = os.Open(local); e != nil {
+		return
+	}
+
+	defer src.Close()
+
+	if dst, e = client.Create(remote); e != nil {
+		return
+	}
+
+	defer dst.Close()
+
+	_, e = io.Copy(dst, src)
+	return
+}
+
+func fileSum(name string) (string, error) {
+
+	f, e := os.Open(name)
+
+	if e != nil {
+		return "", e
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, e = io.Copy(h, f); e != nil {
+		return "", e
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Get working tree files of dir, tracked and untracked files
+// are listed and .gitignore rules are respected.
+func WorkTreeFiles(dir string) (files []string, e error) {
+
+	out, e := Run("git", []string{"-C", dir, "ls-files", "-z", "--cached", "--others", "--exclude-standard"})
+
+	if e != nil {
+		return walkFiles(dir)
+	}
+
+	for _, name := range strings.Split(string(out), "\x00") {
+
+		// Deleted files are still listed until the change is committed.
+		if _, err := os.Lstat(filepath.Join(dir, name)); name != "" && err == nil {
+			files = append(files, name)
+		}
+	}
+
+	return
+}
+
+// Get all files of a directory that is not a git working tree.
+func walkFiles(dir string) (files []string, e error) {
+
+	e = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		files = append(files, rel)
+		return err
+	})
+
+	return
+}
+
+// Create tar.gz archive dst from dir files.
+func Tar(dir string, files []string, dst string) (e error) {
+
+	file, e := os.Create(dst)
+
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	for _, name := range files {
+
+		if e = addToTar(tw, dir, name); e != nil {
+			return
+		}
+	}
+
+	if e = tw.Close(); e != nil {
+		return
+	}
+
+	return gz.Close()
+}
+
+func addToTar(tw *tar.Writer, dir string, name string) (e error) {
+
+	var (
+		link string
+		path = filepath.Join(dir, name)
+	)
+
+	info, e := os.Lstat(path)
+
+	if e != nil {
+		return
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+
+		if link, e = os.Readlink(path); e != nil {
+			return
+		}
+	}
+
+	header, e := tar.FileInfoHeader(info, link)
+
+	if e != nil {
+		return
+	}
+
+	header.Name = filepath.ToSlash(name)
+
+	if e = tw.WriteHeader(header); e != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	file, e := os.Open(path)
+
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	_, e = io.Copy(tw, file)
+	return
+}
@@ -6,34 +6,231 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"strings"
 )
 
-func GitFile(repo string, file string, auth string) (content []byte, e error) {
+type gitFetcher func(repo *url.URL, file string, auth string, ref string) ([]byte, error)
 
-	url, e := url.Parse(repo)
+// Hosts api base urls.
+var (
+	GithubApi    = "https://api.github.com"
+	BitbucketApi = "https://api.bitbucket.org/2.0"
+)
+
+// Git hosts with a files api, self-hosted instances are detected from the
+// host name prefix (e.g: gitlab.example.com) or set with APKER_GIT_HOST.
+var gitFetchers = map[string]gitFetcher{
+	"github":    getGithubFile,
+	"bitbucket": getBitbucketFile,
+	"gitlab":    getGitlabFile,
+	"gitea":     getGiteaFile,
+	"forgejo":   getGiteaFile,
+	"git":       getGitFile,
+}
+
+var gitHosts = map[string]string{
+	"github.com":    "github",
+	"bitbucket.org": "bitbucket",
+	"gitlab.com":    "gitlab",
+	"codeberg.org":  "forgejo",
+	"gitea.com":     "gitea",
+}
+
+var (
+	// Matches scp like ssh remotes: git@host:org/repo.git
+	scpRemote = regexp.MustCompile(`^(?:([^@/]+)@)?([^:/]+):(.+)$`)
+
+	// Matches url credentials in git output.
+	urlUserinfo = regexp.MustCompile(`([a-z][a-z0-9+.-]*://)[^/@\s]+@`)
+
+	commitSha = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+// Get repository file content, ref is a branch, tag or commit
+// and the default branch is used when it's empty.
+func GitFile(repo string, file string, auth string, ref string) (content []byte, e error) {
+
+	u, e := ParseRepoUrl(repo)
+
+	if e != nil {
+		return
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "ssh" {
+		return getGitFile(u, file, auth, ref)
+	}
+
+	return gitFetchers[gitHostType(u.Hostname())](u, file, auth, ref)
+}
+
+// Get repository default branch name (e.g: main or master).
+func DefaultBranch(repo string, auth string) (branch string, e error) {
+
+	u, e := ParseRepoUrl(repo)
+
+	if e != nil {
+		return
+	}
+
+	switch gitHostType(u.Hostname()) {
+	case "github":
+		return defaultBranch(fmt.Sprintf("%s/repos/%s", GithubApi, repoPath(u)), auth)
+	case "bitbucket":
+		return defaultBranch(fmt.Sprintf("%s/repositories/%s", BitbucketApi, repoPath(u)), auth)
+	case "gitea", "forgejo":
+		return defaultBranch(fmt.Sprintf("https://%s/api/v1/repos/%s", u.Hostname(), repoPath(u)), auth)
+	}
+
+	// Gitlab files api and git fetch use the remote HEAD.
+	return
+}
+
+// Get default branch from a repository api response.
+func defaultBranch(api string, auth string) (branch string, e error) {
+
+	var data struct {
+		DefaultBranch string `json:"default_branch"`
+		MainBranch    struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+
+	out, e := GetContentFromUrl(api, auth)
 
 	if e != nil {
 		return
 	}
 
-	switch url.Host {
-	case "github.com":
-		content, e = getGithubFile(url, file, auth)
+	if e = json.Unmarshal(out, &data); e != nil {
 		return
-	case "bitbucket.org":
-		content, e = getBitbucketFile(url, file, auth)
+	}
+
+	// Github and gitea use default_branch, bitbucket uses mainbranch.
+	if branch = data.DefaultBranch; branch == "" {
+		branch = data.MainBranch.Name
+	}
+
+	if branch == "" {
+		e = fmt.Errorf("Default branch not found in: %s", api)
+	}
+
+	return
+}
+
+// Get the commit sha of a remote ref (default: HEAD), empty when the ref is
+// not a branch or tag name (e.g: a short commit sha).
+func RemoteCommit(repo string, auth string, ref string) (commit string, e error) {
+
+	var (
+		u    *url.URL
+		out  []byte
+		refs = map[string]string{}
+	)
+
+	if commitSha.MatchString(ref) {
+		return ref, nil
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if u, e = ParseRepoUrl(repo); e != nil {
 		return
 	}
 
-	e = fmt.Errorf("Unknown host: %s", url.Host)
+	if out, e = Run("git", []string{"ls-remote", UrlAuth(gitRemote(u), auth)}); e != nil {
+		return "", fmt.Errorf("Git ls-remote %s error: %s", RedactUrl(u.String()), RedactUrl(strings.TrimSpace(string(out))))
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+
+	// Annotated tags are peeled to their commit.
+	for _, name := range []string{ref, "refs/heads/" + ref, "refs/tags/" + ref + "^{}", "refs/tags/" + ref} {
+
+		if commit = refs[name]; commit != "" {
+			return
+		}
+	}
 
 	return
 }
 
-func getGithubFile(url *url.URL, file string, auth string) (content []byte, e error) {
+// Remove credentials from urls in s.
+func RedactUrl(s string) string {
 
-	content, e = GetContentFromUrl(fmt.Sprintf("https://api.github.com/repos%s/contents/%s", url.Path, file), auth)
+	return urlUserinfo.ReplaceAllString(s, "$1")
+}
+
+// Parse repository url, scp like ssh remotes are converted to ssh urls.
+func ParseRepoUrl(repo string) (*url.URL, error) {
+
+	if !strings.Contains(repo, "://") {
+
+		if m := scpRemote.FindStringSubmatch(repo); m != nil {
+
+			u := &url.URL{
+				Scheme: "ssh",
+				Host:   m[2],
+				Path:   "/" + strings.TrimPrefix(m[3], "/"),
+			}
+
+			if m[1] != "" {
+				u.User = url.User(m[1])
+			}
+
+			return u, nil
+		}
+	}
+
+	return url.Parse(repo)
+}
+
+func gitHostType(host string) string {
+
+	if t := os.Getenv("APKER_GIT_HOST"); gitFetchers[t] != nil {
+		return t
+	}
+
+	if t, ok := gitHosts[host]; ok {
+		return t
+	}
+
+	for _, t := range []string{"gitlab", "gitea", "forgejo"} {
+
+		if strings.HasPrefix(host, t+".") {
+			return t
+		}
+	}
+
+	return "git"
+}
+
+// Get owner/repo path without .git suffix.
+func repoPath(repo *url.URL) string {
+
+	return strings.TrimSuffix(strings.Trim(repo.Path, "/"), ".git")
+}
+
+func getGithubFile(repo *url.URL, file string, auth string, ref string) (content []byte, e error) {
+
+	api := fmt.Sprintf("%s/repos/%s/contents/%s", GithubApi, repoPath(repo), file)
+
+	if ref != "" {
+		api += "?ref=" + url.QueryEscape(ref)
+	}
+
+	content, e = GetContentFromUrl(api, auth)
 
 	if e != nil {
 		return
@@ -53,8 +250,97 @@ func getGithubFile(url *url.URL, file string, auth string) (content []byte, e er
 	return
 }
 
-func getBitbucketFile(url *url.URL, file string, auth string) ([]byte, error) {
+func getBitbucketFile(repo *url.URL, file string, auth string, ref string) (content []byte, e error) {
+
+	// Bitbucket src api requires a ref.
+	if ref == "" {
+
+		if ref, e = defaultBranch(fmt.Sprintf("%s/repositories/%s", BitbucketApi, repoPath(repo)), auth); e != nil {
+			return
+		}
+	}
+
+	return GetContentFromUrl(fmt.Sprintf("%s/repositories/%s/src/%s/%s", BitbucketApi, repoPath(repo), url.PathEscape(ref), file), auth)
+}
+
+func getGitlabFile(repo *url.URL, file string, auth string, ref string) ([]byte, error) {
+
+	header := http.Header{}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// Gitlab api needs the token alone, auth may be "user:token".
+	if auth != "" {
+		header.Set("PRIVATE-TOKEN", auth[strings.Index(auth, ":")+1:])
+	}
+
+	return GetContent(fmt.Sprintf(
+		"https://%s/api/v4/projects/%s/repository/files/%s/raw?ref=%s",
+		repo.Hostname(), url.PathEscape(repoPath(repo)), url.PathEscape(file), url.QueryEscape(ref),
+	), header)
+}
+
+func getGiteaFile(repo *url.URL, file string, auth string, ref string) ([]byte, error) {
+
+	api := fmt.Sprintf("https://%s/api/v1/repos/%s/raw/%s", repo.Hostname(), repoPath(repo), file)
+
+	if ref != "" {
+		api += "?ref=" + url.QueryEscape(ref)
+	}
+
+	return GetContentFromUrl(api, auth)
+}
+
+// Fetch file with a shallow git fetch, works with any git remote.
+func getGitFile(repo *url.URL, file string, auth string, ref string) (content []byte, e error) {
+
+	var out []byte
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	dir, e := ioutil.TempDir(os.TempDir(), "apker-git-")
+
+	if e != nil {
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, args := range [][]string{
+		{"init", "-q", dir},
+		{"-C", dir, "fetch", "-q", "--depth", "1", UrlAuth(gitRemote(repo), auth), ref},
+	} {
+
+		// Git output may echo the remote url with its credentials.
+		if out, e = Run("git", args); e != nil {
+			return nil, fmt.Errorf("Git fetch %s error: %s", RedactUrl(repo.String()), RedactUrl(strings.TrimSpace(string(out))))
+		}
+	}
+
+	if content, e = Run("git", []string{"-C", dir, "show", "FETCH_HEAD:" + file}); e != nil {
+		return nil, fmt.Errorf("Git file %s not found in %s", file, RedactUrl(repo.String()))
+	}
+
+	return
+}
+
+// Get git remote, scp like remotes are kept as is, ssh config may rely on them.
+func gitRemote(repo *url.URL) string {
+
+	if repo.Scheme == "ssh" && repo.Port() == "" {
+
+		remote := fmt.Sprintf("%s:%s", repo.Host, strings.TrimPrefix(repo.Path, "/"))
+
+		if repo.User != nil {
+			remote = repo.User.Username() + "@" + remote
+		}
+
+		return remote
+	}
 
-	// IDK how to get default branch, using master as default!
-	return GetContentFromUrl(fmt.Sprintf("https://api.bitbucket.org/2.0/repositories%s/src/master/%s", url.Path, file), auth)
+	return repo.String()
 }
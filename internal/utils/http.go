@@ -13,7 +13,23 @@ import (
 
 func GetContentFromUrl(url string, auth string) (content []byte, e error) {
 
-	res, e := http.Get(UrlAuth(url, auth))
+	return GetContent(UrlAuth(url, auth), nil)
+}
+
+// Get url content with request headers.
+func GetContent(url string, header http.Header) (content []byte, e error) {
+
+	req, e := http.NewRequest(http.MethodGet, url, nil)
+
+	if e != nil {
+		return
+	}
+
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+
+	res, e := http.DefaultClient.Do(req)
 
 	if e != nil {
 		return
@@ -23,7 +39,9 @@ func GetContentFromUrl(url string, auth string) (content []byte, e error) {
 
 	if res.StatusCode != http.StatusOK {
 
-		e = fmt.Errorf("Request error: %v for %s", res.StatusCode, url)
+		// Don't leak url credentials.
+		req.URL.User = nil
+		e = fmt.Errorf("Request error: %v for %s", res.StatusCode, req.URL.String())
 		return
 	}
 
@@ -0,0 +1,82 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Generate ed25519 key pair, the private key is in openssh pem format.
+func GenerateKey(comment string) (private []byte, public ssh.PublicKey, e error) {
+
+	pub, prv, e := ed25519.GenerateKey(rand.Reader)
+
+	if e != nil {
+		return
+	}
+
+	if public, e = ssh.NewPublicKey(pub); e != nil {
+		return
+	}
+
+	private, e = marshalED25519(prv, public, comment)
+	return
+}
+
+// Marshal unencrypted ed25519 private key to the openssh-key-v1 format.
+func marshalED25519(key ed25519.PrivateKey, pub ssh.PublicKey, comment string) (_ []byte, e error) {
+
+	var check [4]byte
+
+	if _, e = rand.Read(check[:]); e != nil {
+		return
+	}
+
+	prv := struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+		Pad     []byte `ssh:"rest"`
+	}{
+		Check1:  binary.BigEndian.Uint32(check[:]),
+		Check2:  binary.BigEndian.Uint32(check[:]),
+		Keytype: ssh.KeyAlgoED25519,
+		Pub:     key.Public().(ed25519.PublicKey),
+		Priv:    key,
+		Comment: comment,
+	}
+
+	// Private section is padded to the cipher block size, 8 for none.
+	for i := 0; (len(ssh.Marshal(prv)))%8 != 0; i++ {
+		prv.Pad = append(prv.Pad, byte(i+1))
+	}
+
+	data := struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{
+		CipherName:   "none",
+		KdfName:      "none",
+		NumKeys:      1,
+		PubKey:       pub.Marshal(),
+		PrivKeyBlock: ssh.Marshal(prv),
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), ssh.Marshal(data)...),
+	}), nil
+}
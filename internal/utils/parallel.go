@@ -0,0 +1,37 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package utils
+
+import "sync"
+
+// Call fn for each index from 0 to n-1, with at most limit concurrent calls.
+func Parallel(n int, limit int, fn func(i int)) {
+
+	if limit < 1 || limit > n {
+		limit = n
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, limit)
+	)
+
+	for i := 0; i < n; i++ {
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+	envName   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Quote string as a single argument for POSIX shells.
+func ShellQuote(s string) string {
+
+	if s == "" {
+		return "''"
+	}
+
+	if shellSafe.MatchString(s) {
+		return s
+	}
+
+	// Single quotes keep everything literal, only a single quote
+	// itself needs to close the string, be escaped, and reopen it.
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Quote and join arguments to a shell command.
+func ShellJoin(args ...string) string {
+
+	quoted := make([]string, len(args))
+
+	for i, arg := range args {
+		quoted[i] = ShellQuote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// Get shell command that runs cmd with bash.
+func BashCommand(cmd string) string {
+
+	return "bash -c " + ShellQuote(cmd)
+}
+
+// Get NAME=VALUE env assignment with a quoted value, names can't be
+// quoted so invalid ones are rejected.
+func ShellEnv(name string, value string) (string, error) {
+
+	if !envName.MatchString(name) {
+		return "", fmt.Errorf("Invalid env variable name: %q", name)
+	}
+
+	return name + "=" + ShellQuote(value), nil
+}
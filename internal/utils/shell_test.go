@@ -0,0 +1,100 @@
+// Copyright 2020 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package utils
+
+import (
+	"os/exec"
+	"testing"
+)
+
+var shellCases = []struct {
+	name  string
+	value string
+}{
+	{"empty", ""},
+	{"plain", "hello"},
+	{"spaces", "hello world"},
+	{"single quotes", "it's 'quoted'"},
+	{"double quotes", `say "hi"`},
+	{"dollar", "$HOME ${PATH} $(id)"},
+	{"backticks", "`id` and `whoami`"},
+	{"newlines", "line one\nline two\n"},
+	{"separators", "a; b && c | d > e"},
+	{"backslashes", `C:\path\to\ 'x'`},
+}
+
+func bash(t *testing.T, cmd string) string {
+
+	t.Helper()
+
+	out, e := exec.Command("bash", "-c", cmd).Output()
+
+	if e != nil {
+		t.Fatalf("bash -c %s: %s", cmd, e)
+	}
+
+	return string(out)
+}
+
+func TestShellQuote(t *testing.T) {
+
+	if _, e := exec.LookPath("bash"); e != nil {
+		t.Skip("bash not found")
+	}
+
+	for _, c := range shellCases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			if got := bash(t, "printf %s "+ShellQuote(c.value)); got != c.value {
+				t.Errorf("got %q, want %q", got, c.value)
+			}
+
+			// Quoted twice: bash command run by bash.
+			if got := bash(t, BashCommand("printf %s "+ShellQuote(c.value))); got != c.value {
+				t.Errorf("bash command: got %q, want %q", got, c.value)
+			}
+		})
+	}
+}
+
+func TestShellEnv(t *testing.T) {
+
+	if _, e := exec.LookPath("bash"); e != nil {
+		t.Skip("bash not found")
+	}
+
+	for _, c := range shellCases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			env, e := ShellEnv("APKER_TEST", c.value)
+
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			if got := bash(t, "env "+env+` bash -c 'printf %s "$APKER_TEST"'`); got != c.value {
+				t.Errorf("got %q, want %q", got, c.value)
+			}
+		})
+	}
+}
+
+func TestShellEnvInvalidName(t *testing.T) {
+
+	for _, name := range []string{"", "1X", "X-Y", "X Y", "X=1", "X;rm -rf /;Y", "$X", "X`id`"} {
+
+		if _, e := ShellEnv(name, "value"); e == nil {
+			t.Errorf("ShellEnv(%q): expected error", name)
+		}
+	}
+
+	for _, name := range []string{"X", "_x", "APKER_ACTION", "a1_B2"} {
+
+		if _, e := ShellEnv(name, "value"); e != nil {
+			t.Errorf("ShellEnv(%q): %s", name, e)
+		}
+	}
+}
@@ -4,70 +4,109 @@
 package utils
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"path"
-	"time"
+	"strings"
 
+	"github.com/melbahja/goph"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
-func GetFingerprint(keyPath string, algro string) (string, error) {
+// Default private keys names in ~/.ssh, in order of preference.
+var DefaultKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
 
-	var (
-		e    error
-		file []byte
-	)
+// Find the first default private key in ~/.ssh.
+func FindSSHKey() (string, error) {
 
-	if file, e = ioutil.ReadFile(keyPath); e != nil {
+	for _, name := range DefaultKeys {
 
-		return "", e
+		key := path.Join(os.Getenv("HOME"), ".ssh", name)
 
-	} else if key, _, _, _, e := ssh.ParseAuthorizedKey(file); e == nil {
+		if _, e := os.Stat(key); e == nil {
+			return key, nil
+		}
+	}
 
-		switch algro {
+	return "", fmt.Errorf("No ssh private key found in ~/.ssh (%s), set one with --key.", strings.Join(DefaultKeys, ", "))
+}
 
-		case "md5":
-			return ssh.FingerprintLegacyMD5(key), nil
+// Check if private key requires a passphrase.
+func IsEncryptedKey(prv string) bool {
 
-		case "sha256":
-			return ssh.FingerprintSHA256(key), nil
-		}
+	privateKey, e := ioutil.ReadFile(prv)
+
+	if e != nil {
+		return false
 	}
 
-	return "", e
+	_, e = ssh.ParsePrivateKey(privateKey)
+	_, ok := e.(*ssh.PassphraseMissingError)
+	return ok
 }
 
-func ResolveSSHKeys(publicKeyPath string, privateKeyPath string) (pubfp string, pub string, prv string, e error) {
+// Get private key signers, the key openssh certificate (key-cert.pub)
+// signer comes first when found.
+func KeySigners(prv string, passphrase string) (signers []ssh.Signer, e error) {
 
-	if publicKeyPath == "" {
-		publicKeyPath = path.Join(os.Getenv("HOME"), ".ssh", "id_rsa.pub")
-	}
+	var (
+		data   []byte
+		pub    ssh.PublicKey
+		signer ssh.Signer
+	)
 
-	if privateKeyPath == "" {
-		privateKeyPath = path.Join(os.Getenv("HOME"), ".ssh", "id_rsa")
+	if signer, e = goph.GetSigner(prv, passphrase); e != nil {
+		return
 	}
 
-	if pubfp, e = GetFingerprint(publicKeyPath, "md5"); e != nil {
+	signers = []ssh.Signer{signer}
+
+	if data, e = ioutil.ReadFile(prv + "-cert.pub"); os.IsNotExist(e) {
+		return signers, nil
+	} else if e != nil {
+		return
+	}
 
+	if pub, _, _, _, e = ssh.ParseAuthorizedKey(data); e != nil {
 		return
+	}
 
-	} else if _, e = os.Stat(privateKeyPath); e != nil {
+	cert, ok := pub.(*ssh.Certificate)
 
+	if !ok {
+		return nil, fmt.Errorf("Invalid ssh certificate: %s-cert.pub", prv)
+	}
+
+	if signer, e = ssh.NewCertSigner(cert, signer); e != nil {
 		return
 	}
 
-	pub = publicKeyPath
-	prv = privateKeyPath
-	return
+	return append([]ssh.Signer{signer}, signers...), nil
 }
 
-func IsPortOpen(addr string, tSeconds int) bool {
+// Get ssh agent signers, certificates loaded in the agent are included.
+func AgentSigners() ([]ssh.Signer, error) {
 
-	_, e := net.DialTimeout("tcp", addr, time.Duration(tSeconds)*time.Second)
+	conn, e := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
 
-	return e == nil
+	if e != nil {
+		return nil, fmt.Errorf("Could not connect to ssh agent: %s", e.Error())
+	}
+
+	return agent.NewClient(conn).Signers()
+}
+
+// Get public key, certificates are resolved to their key.
+func PlainKey(key ssh.PublicKey) ssh.PublicKey {
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return cert.Key
+	}
+
+	return key
 }
 
 func IsValidPassphrase(prv string, passphrase string) bool {
@@ -24,6 +24,7 @@ func init() {
 		Version:              version,
 		Flags:                cmd.Flags,
 		Commands:             cmd.Commands,
+		Before:               cmd.Before,
 		EnableBashCompletion: true,
 		Authors: []*cli.Author{
 			&cli.Author{